@@ -0,0 +1,109 @@
+package main
+
+import (
+	"archive/zip"
+	"bytes"
+	"io/ioutil"
+	"path"
+	"strings"
+)
+
+// archiveMode is set by the --archive option, in which case each argument
+// is a zip archive, such as a module package downloaded from a registry,
+// and we process the configuration files inside it without extracting them.
+var archiveMode bool
+
+// processArchive processes each of the configuration files in the zip
+// archive with the given name, in the same way as processFile but without
+// modifying anything. Each file is named in messages by the archive's name
+// followed by the file's path within it.
+func processArchive(fn string) {
+	r, err := zip.OpenReader(fn)
+	if err != nil {
+		reportError(fn, "Failed to open archive %q: %s", fn, err)
+		return
+	}
+	defer r.Close()
+
+	for _, entry := range r.File {
+		if entry.FileInfo().IsDir() {
+			continue
+		}
+		entryFn := fn + "/" + archiveEntryPath(entry.Name)
+		if skipArchiveEntry(entryFn, entry.UncompressedSize64) {
+			continue
+		}
+		processArchiveEntry(entryFn, entry)
+	}
+}
+
+// archiveEntryPath returns the cleaned path of the entry with the given name
+// in an archive, without any leading slash.
+func archiveEntryPath(name string) string {
+	return strings.TrimPrefix(path.Clean(name), "/")
+}
+
+// skipArchiveEntry returns true, after logging the reason, if we shouldn't
+// process the file from an archive that is named by the given name in
+// messages and has the given size.
+func skipArchiveEntry(fn string, size uint64) bool {
+	switch {
+	case isExcluded(fn):
+		logSkip(fn, "matches an --exclude pattern")
+	case strings.HasSuffix(fn, ".tf.json") || strings.HasSuffix(fn, ".tfvars.json"):
+		logInfo("Skipping %q: JSON configuration files are not supported", fn)
+	case !hasCleanableSuffix(fn):
+		logSkip(fn, "filename suffix is not one to process")
+	case !isIncluded(fn):
+		logSkip(fn, "doesn't match any --include pattern")
+	case maxFileSize > 0 && size > uint64(maxFileSize):
+		reportSkipped(fn, "Skipping %q: larger than the maximum file size of %d bytes", fn, maxFileSize)
+	default:
+		return false
+	}
+	return true
+}
+
+// processArchiveEntry processes the given file from an archive, which is
+// named by the given name in messages.
+func processArchiveEntry(fn string, entry *zip.File) {
+	rc, err := entry.Open()
+	if err != nil {
+		reportError(fn, "Failed to read %q: %s", fn, err)
+		return
+	}
+	src, err := ioutil.ReadAll(rc)
+	rc.Close()
+	if err != nil {
+		reportError(fn, "Failed to read %q: %s", fn, err)
+		return
+	}
+	resultsMu.Lock()
+	filesProcessed++
+	resultsMu.Unlock()
+
+	newSrc, stats, _, ok := cleanSource(src, fn)
+	if !ok {
+		return
+	}
+	if bytes.Equal(newSrc, src) {
+		reportResult(fn, false, stats)
+		return
+	}
+
+	if checkMode || listMode || previewMode {
+		resultsMu.Lock()
+		uncleanFiles = append(uncleanFiles, fn)
+		uncleanChanges[fn] = stats.Total()
+		resultsMu.Unlock()
+	}
+	if mode == outputDiff {
+		stdoutMu.Lock()
+		err := writeStdoutDiff(fn, src, newSrc)
+		stdoutMu.Unlock()
+		if err != nil {
+			reportError(fn, "Failed to write diff for %q: %s", fn, err)
+		}
+	}
+	reportResult(fn, true, stats)
+}
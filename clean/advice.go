@@ -0,0 +1,48 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// Advisory describes some legacy syntax in an argument that the cleaning
+// rules deliberately left unchanged, because they can't rewrite it without
+// risking a change in meaning. These are left for the user to migrate by
+// hand.
+type Advisory struct {
+	// Range is the location of the argument in the original source code.
+	Range hcl.Range
+
+	// Message describes the syntax that was left unchanged, and why.
+	Message string
+}
+
+// advise records an advisory message about the argument currently being
+// cleaned, if the cleaner is recording advisories.
+func (c *cleaner) advise(msg string) {
+	if c.advisories == nil {
+		return
+	}
+	c.pendingAdvice = append(c.pendingAdvice, msg)
+}
+
+// recordAdvice records the advisory messages about the argument named in
+// the given body, if we know the location of the argument, and then
+// discards them so that they don't also apply to the next argument.
+func (c *cleaner) recordAdvice(syntaxBody *hclsyntax.Body, name string) {
+	msgs := c.pendingAdvice
+	c.pendingAdvice = nil
+	if syntaxBody == nil {
+		return
+	}
+	attr, ok := syntaxBody.Attributes[name]
+	if !ok {
+		return
+	}
+	for _, msg := range msgs {
+		c.advisories = append(c.advisories, Advisory{
+			Range:   attr.SrcRange,
+			Message: msg,
+		})
+	}
+}
@@ -0,0 +1,39 @@
+package clean
+
+import (
+	"testing"
+)
+
+func TestConcatAdvisory(t *testing.T) {
+	const want = "template concatenating the interpolations of var.a and var.b, with no literal text between them, was left unchanged because it can't be unwrapped into a single expression"
+	tests := []struct {
+		name string
+		src  string
+		want []string
+	}{
+		{"no literal text", "a = \"${var.a}${var.b}\"\n", []string{want}},
+		// With literal text between them, the template is just a template
+		// that happens to contain several interpolations.
+		{"literal text between", "a = \"${var.a}-${var.b}\"\n", nil},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			_, result, diags := Options{}.BytesResult([]byte(test.src), "test.tf")
+			if diags.HasErrors() {
+				t.Fatalf("unexpected errors: %s", diags.Error())
+			}
+			if len(result.Advisories) != len(test.want) {
+				t.Fatalf("wrong advisories %#v; want %q", result.Advisories, test.want)
+			}
+			for i, advisory := range result.Advisories {
+				if advisory.Message != test.want[i] {
+					t.Errorf("wrong advisory %d\ngot:  %s\nwant: %s", i, advisory.Message, test.want[i])
+				}
+				if line := advisory.Range.Start.Line; line != 1 {
+					t.Errorf("advisory %d is on line %d; want 1", i, line)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,65 @@
+package clean
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Bytes parses the given source code of a configuration file, applies all of
+// the cleaning rules to it, and returns the cleaned source code, along with
+// true if it differs from the original. The given filename is used in any
+// diagnostics.
+//
+// If none of the rules apply, the result is the given source code, exactly
+// as it was. Otherwise, the result is formatted in the same way as by
+// Format. If the source code is invalid, the result is nil and the
+// diagnostics describe the problems.
+func Bytes(src []byte, filename string) ([]byte, bool, hcl.Diagnostics) {
+	return Options{}.Bytes(src, filename)
+}
+
+// Bytes is like the package-level function Bytes, but also applies the
+// optional rules enabled in the receiver.
+func (o Options) Bytes(src []byte, filename string) ([]byte, bool, hcl.Diagnostics) {
+	ret, _, diags := o.bytes(src, filename, false)
+	if diags.HasErrors() {
+		return nil, false, diags
+	}
+	return ret, !bytes.Equal(ret, src), diags
+}
+
+// BytesResult is like Bytes, but returns a description of the changes made
+// and of any legacy syntax left unchanged, as for FileResult.
+func (o Options) BytesResult(src []byte, filename string) ([]byte, Result, hcl.Diagnostics) {
+	return o.bytes(src, filename, true)
+}
+
+// bytes implements both Bytes and BytesResult. If detailed is false then
+// only the Stats of the result are populated.
+func (o Options) bytes(src []byte, filename string, detailed bool) ([]byte, Result, hcl.Diagnostics) {
+	f, diags := hclwrite.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		return nil, Result{}, diags
+	}
+	// We must check this before the rules modify the file.
+	roundTripDiags := checkRoundTrip(f, src, filename)
+
+	var result Result
+	if detailed {
+		result = o.FileResult(f, filename)
+	} else {
+		result.Stats = o.File(f)
+	}
+	if !result.Stats.Changed() && !o.Format {
+		// If none of the rules made any changes then we return the original
+		// source, to avoid making any formatting changes to a file that is
+		// already clean.
+		return src, result, nil
+	}
+	if roundTripDiags.HasErrors() {
+		return nil, Result{}, roundTripDiags
+	}
+	return fixNotSpacing(f.Bytes()), result, nil
+}
@@ -0,0 +1,89 @@
+package clean
+
+import (
+	"testing"
+)
+
+func TestBytes(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    Options
+		src     string
+		want    string
+		changed bool
+	}{
+		{
+			name:    "dirty",
+			src:     "a = \"${b}\"\nc   = \"d\"\n",
+			want:    "a = b\nc = \"d\"\n",
+			changed: true,
+		},
+		{
+			// A file that doesn't need cleaning is returned exactly as it
+			// was, without formatting it.
+			name:    "clean",
+			src:     "a = b\nc   = \"d\"\n",
+			want:    "a = b\nc   = \"d\"\n",
+			changed: false,
+		},
+		{
+			name:    "clean with Format",
+			opts:    Options{Format: true},
+			src:     "a = b\nc   = \"d\"\n",
+			want:    "a = b\nc = \"d\"\n",
+			changed: true,
+		},
+		{
+			name:    "empty",
+			src:     "",
+			want:    "",
+			changed: false,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			got, changed, diags := test.opts.Bytes([]byte(test.src), "test.tf")
+			if diags.HasErrors() {
+				t.Fatalf("unexpected errors: %s", diags.Error())
+			}
+			if string(got) != test.want {
+				t.Errorf("wrong result\ngot:\n%s\nwant:\n%s", got, test.want)
+			}
+			if changed != test.changed {
+				t.Errorf("wrong changed %t; want %t", changed, test.changed)
+			}
+		})
+	}
+}
+
+func TestBytesInvalid(t *testing.T) {
+	got, changed, diags := Bytes([]byte("a = \"${b}\"\nc = \n"), "test.tf")
+	if !diags.HasErrors() {
+		t.Fatalf("no errors")
+	}
+	if got != nil || changed {
+		t.Errorf("wrong result %q, %t; want nil, false", got, changed)
+	}
+	if subject := diags[0].Subject; subject == nil || subject.Filename != "test.tf" || subject.Start.Line != 2 {
+		t.Errorf("wrong subject %#v", subject)
+	}
+}
+
+func TestBytesResult(t *testing.T) {
+	src := "a = \"${b}\"\n\nvariable \"v\" {\n  type = \"string\"\n}\n"
+	_, result, diags := Options{}.BytesResult([]byte(src), "test.tf")
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Error())
+	}
+	if got, want := result.Stats, (Stats{Interpolations: 1, TypeConstraints: 1}); got != want {
+		t.Errorf("wrong stats %#v; want %#v", got, want)
+	}
+	if len(result.Changes) != 2 {
+		t.Fatalf("wrong number of changes %d; want 2", len(result.Changes))
+	}
+	for i, wantLine := range []int{1, 4} {
+		if got := result.Changes[i].Range.Start.Line; got != wantLine {
+			t.Errorf("change %d is on line %d; want %d", i, got, wantLine)
+		}
+	}
+}
@@ -0,0 +1,93 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// elementCall rewrites a call to the element function, like
+// element(foo, count.index), into the equivalent index expression, like
+// foo[count.index].
+//
+// This is conservative and only rewrites calls whose first argument is a
+// simple traversal, so that the index can be applied to it directly.
+// If the traversal includes a splat operator then the result is wrapped
+// in parentheses, so that the index applies to the result of the splat.
+//
+// Note that element wraps around when given an index greater than the
+// length of the list, while the index syntax will instead produce an error,
+// so this is only done if enabled by Options.ElementCalls.
+func (c *cleaner) elementCall(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	name, args, ok := functionCallArgs(tokens)
+	if !ok || name != "element" || len(args) != 2 {
+		return tokens, false
+	}
+	ret, ok := indexExpr(args[0], args[1])
+	if !ok {
+		c.advise("call to element was left unchanged because its first argument is not a simple reference")
+		return tokens, false
+	}
+	c.stats.IndexCalls++
+	return ret, true
+}
+
+// lookupCall rewrites a call to the lookup function with only two
+// arguments, like lookup(foo, "bar"), into the equivalent index
+// expression, like foo["bar"].
+//
+// A call with a third argument, giving a default value, has no equivalent
+// index syntax and so is left unchanged.
+func (c *cleaner) lookupCall(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	name, args, ok := functionCallArgs(tokens)
+	if !ok || name != "lookup" {
+		return tokens, false
+	}
+	if len(args) == 3 {
+		c.advise("call to lookup with a default value was left unchanged because it has no equivalent index syntax")
+		return tokens, false
+	}
+	if len(args) != 2 {
+		return tokens, false
+	}
+	ret, ok := indexExpr(args[0], args[1])
+	if !ok {
+		c.advise("call to lookup was left unchanged because its first argument is not a simple reference")
+		return tokens, false
+	}
+	c.stats.IndexCalls++
+	return ret, true
+}
+
+// indexExpr builds an index expression applying the given key to the given
+// collection, or returns false if the collection is not something we can
+// safely apply an index to.
+func indexExpr(coll, key hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if !isTraversal(coll) {
+		return nil, false
+	}
+
+	ret := make(hclwrite.Tokens, 0, len(coll)+len(key)+4)
+	if hasSplat(coll) {
+		ret = append(ret, &hclwrite.Token{
+			Type:  hclsyntax.TokenOParen,
+			Bytes: []byte("("),
+		})
+		ret = append(ret, coll...)
+		ret = append(ret, &hclwrite.Token{
+			Type:  hclsyntax.TokenCParen,
+			Bytes: []byte(")"),
+		})
+	} else {
+		ret = append(ret, coll...)
+	}
+	ret = append(ret, &hclwrite.Token{
+		Type:  hclsyntax.TokenOBrack,
+		Bytes: []byte("["),
+	})
+	ret = append(ret, key...)
+	ret = append(ret, &hclwrite.Token{
+		Type:  hclsyntax.TokenCBrack,
+		Bytes: []byte("]"),
+	})
+	return ret, true
+}
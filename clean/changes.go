@@ -0,0 +1,103 @@
+package clean
+
+import (
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Change describes the changes made to a single argument.
+type Change struct {
+	// Range is the location of the argument in the original source code.
+	Range hcl.Range
+
+	// Stats counts the changes made to the argument's value.
+	Stats Stats
+}
+
+// Result describes the changes made to a file by FileResult, along with
+// any advisories about legacy syntax that was left unchanged.
+type Result struct {
+	// Stats counts all of the changes made to the file.
+	Stats Stats
+
+	// Changes describes the changes made to each argument, ordered by their
+	// location in the original source code.
+	Changes []Change
+
+	// Advisories describes the legacy syntax that was left unchanged,
+	// ordered by location in the same way as Changes.
+	Advisories []Advisory
+}
+
+// FileChanges is like File, but also returns a description of the changes
+// made to each argument, ordered by their location in the original source
+// code. The given filename is used in the ranges of the changes.
+func FileChanges(f *hclwrite.File, filename string) (Stats, []Change) {
+	return Options{}.FileChanges(f, filename)
+}
+
+// FileChanges is like the package-level function FileChanges, but also
+// applies the optional rules enabled in the receiver.
+func (o Options) FileChanges(f *hclwrite.File, filename string) (Stats, []Change) {
+	result := o.FileResult(f, filename)
+	return result.Stats, result.Changes
+}
+
+// FileResult is like FileChanges, but also returns advisories about any
+// legacy syntax that the rules enabled in the receiver left unchanged.
+func (o Options) FileResult(f *hclwrite.File, filename string) Result {
+	// hclwrite doesn't track source locations, so we'll parse the original
+	// source code again to find them, before we make any changes.
+	src := f.BuildTokens(nil).Bytes()
+	var syntaxBody *hclsyntax.Body
+	if sf, diags := hclsyntax.ParseConfig(src, filename, hcl.Pos{Line: 1, Column: 1}); !diags.HasErrors() {
+		syntaxBody, _ = sf.Body.(*hclsyntax.Body)
+	}
+
+	c := cleaner{
+		opts:       o,
+		changes:    []Change{},
+		advisories: []Advisory{},
+	}
+	c.body(f.Body(), syntaxBody, nil)
+	sort.SliceStable(c.changes, func(i, j int) bool {
+		return c.changes[i].Range.Start.Byte < c.changes[j].Range.Start.Byte
+	})
+	sort.SliceStable(c.advisories, func(i, j int) bool {
+		return c.advisories[i].Range.Start.Byte < c.advisories[j].Range.Start.Byte
+	})
+	return Result{
+		Stats:      c.stats,
+		Changes:    c.changes,
+		Advisories: c.advisories,
+	}
+}
+
+// recordChange records the change from the given stats to the current stats
+// as a change to the argument named in the given body, if the cleaner is
+// recording changes and we know the location of the argument.
+func (c *cleaner) recordChange(syntaxBody *hclsyntax.Body, name string, before Stats) {
+	if c.changes == nil || syntaxBody == nil {
+		return
+	}
+	attr, ok := syntaxBody.Attributes[name]
+	if !ok {
+		return
+	}
+	c.changes = append(c.changes, Change{
+		Range: attr.SrcRange,
+		Stats: c.stats.minus(before),
+	})
+}
+
+// syntaxBlockBody returns the body of the block at the given index in the
+// given body, or nil if the body is nil or has no such block.
+func syntaxBlockBody(syntaxBody *hclsyntax.Body, i int) *hclsyntax.Body {
+	if syntaxBody == nil || i >= len(syntaxBody.Blocks) {
+		return nil
+	}
+	return syntaxBody.Blocks[i].Body
+}
@@ -0,0 +1,627 @@
+// Package clean contains the cleaning rules used by terraform-clean-syntax,
+// which rewrite legacy Terraform configuration syntax into its modern
+// equivalent.
+//
+// The functions in this package operate on the hclwrite representation of
+// a configuration file, so that the rest of the file is left unchanged.
+package clean
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// File applies all of the cleaning rules to the given file, modifying it
+// in-place.
+//
+// The result describes the changes that were made. If it reports no changes,
+// the file is left exactly as it was.
+func File(f *hclwrite.File) Stats {
+	return Options{}.File(f)
+}
+
+// ValueExpr returns a cleaned version of the given tokens representing an
+// argument value expression, along with a description of the changes made.
+//
+// If the expression consists only of a single template interpolation
+// sequence, like "${foo}", the result is the expression inside the
+// interpolation. If the expression is a tuple constructor, like
+// ["${foo}", "bar"], then each of its elements is cleaned in the same way,
+// and likewise for each of the values in an object constructor, like
+// { a = "${foo}" }, and each of the arguments in a function call, like
+// join(",", "${foo}"), and each interpolated expression in a template that
+// can't itself be unwrapped, like "a-${join(",", ["${foo}"])}". Redundant
+// parentheses around an unwrapped expression, as in "${(foo)}", are removed.
+// Two-argument calls to the lookup function are replaced with the
+// equivalent index syntax.
+// Otherwise, the tokens are returned verbatim, so a string without any
+// interpolations, like the version constraint "~> 3.0", is never changed.
+func ValueExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, Stats) {
+	var c cleaner
+	ret, _ := c.valueExpr(tokens)
+	return ret, c.stats
+}
+
+// ProviderExpr returns a cleaned version of the given tokens representing
+// the value of a "provider" argument in a resource or data block, along with
+// a description of the changes made.
+//
+// If the expression is a legacy quoted provider reference, like "aws.foo",
+// or an interpolation of a reference, like "${aws.foo}", the result is the
+// equivalent bare reference. Otherwise, the tokens are returned verbatim.
+func ProviderExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, Stats) {
+	var c cleaner
+	ret, _ := c.providerExpr(tokens)
+	return ret, c.stats
+}
+
+// TypeExpr returns a cleaned version of the given tokens representing the
+// value of a "type" argument in a variable block, along with a description
+// of the changes made.
+//
+// If the expression is one of the legacy quoted type constraints, like
+// "string" or "list", the result is the equivalent type constraint
+// expression. Otherwise, the tokens are returned verbatim.
+func TypeExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, Stats) {
+	var c cleaner
+	ret, _ := c.typeExpr(tokens)
+	return ret, c.stats
+}
+
+// cleaner applies the cleaning rules, keeping a count of the changes it
+// makes.
+//
+// Each of the cleaning methods returns the cleaned tokens along with a
+// boolean that is true if the result differs from the input.
+//
+// If changes is not nil, the cleaner also records each changed argument
+// whose location it knows, and likewise if advisories is not nil it records
+// advice about the legacy syntax it left unchanged. pendingAdvice holds the
+// advice about the argument currently being cleaned.
+type cleaner struct {
+	opts          Options
+	stats         Stats
+	changes       []Change
+	advisories    []Advisory
+	pendingAdvice []string
+}
+
+// body cleans all of the attributes in the given body, and then recursively
+// in each of its nested blocks. This includes the content blocks of dynamic
+// blocks, whose attributes are cleaned like any other. The value of each
+// attribute is given to each of the rules from Options.rules in turn.
+//
+// syntaxBody, if not nil, is the result of parsing the same body with
+// hclsyntax, which we use to find the location of each argument.
+//
+// inBlocks gives the types of the blocks that the body is nested within,
+// starting with the outermost, so that it's empty for the root body of a
+// file and has one element for the body of a top-level block.
+func (c *cleaner) body(body *hclwrite.Body, syntaxBody *hclsyntax.Body, inBlocks []string) bool {
+	changed := false
+	rules := c.opts.rules()
+	attrs := body.Attributes()
+	for name, attr := range attrs {
+		tokens := attr.Expr().BuildTokens(nil)
+		before := c.stats
+		exprChanged := false
+		// The user may have asked us to leave some arguments as they are.
+		if !c.opts.keepWrapped(name) {
+			arg := argument{name: name, inBlocks: inBlocks}
+			for _, rule := range rules {
+				if result, ruleChanged := rule(c, arg, tokens); ruleChanged {
+					tokens = result
+					exprChanged = true
+				}
+			}
+		}
+		if exprChanged {
+			body.SetAttributeRaw(name, tokens)
+			c.recordChange(syntaxBody, name, before)
+			changed = true
+		}
+		c.recordAdvice(syntaxBody, name)
+	}
+
+	blocks := body.Blocks()
+	for i, block := range blocks {
+		// We copy inBlocks here, rather than appending to it directly,
+		// because append could otherwise reuse the same backing array for
+		// each of the sibling blocks.
+		blockPath := make([]string, len(inBlocks), len(inBlocks)+1)
+		copy(blockPath, inBlocks)
+		blockPath = append(blockPath, block.Type())
+		if c.body(block.Body(), syntaxBlockBody(syntaxBody, i), blockPath) {
+			changed = true
+		}
+	}
+	return changed
+}
+
+// valueExpr cleans an argument value expression, as described for
+// ValueExpr.
+func (c *cleaner) valueExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if inside, ok := unwrapInterpolation(tokens); ok {
+		c.stats.Interpolations++
+		// Parentheses around the whole of the interpolated expression, as
+		// in "${(foo)}", are redundant once it's no longer inside a
+		// template, and the interpolated expression may itself have some
+		// nested parts that we can clean, so we'll recursively visit it.
+		cleaned, _ := c.valueExpr(stripParens(inside))
+		return cleaned, true
+	}
+	if isBracketed(tokens, hclsyntax.TokenOQuote) {
+		return c.templateExpr(tokens)
+	}
+	if isBracketed(tokens, hclsyntax.TokenOBrack) {
+		return c.tupleExpr(tokens)
+	}
+	if isBracketed(tokens, hclsyntax.TokenOBrace) {
+		return c.objectExpr(tokens)
+	}
+	if isFunctionCall(tokens) {
+		// We clean the arguments first, because that may make a call
+		// eligible for one of the function-specific rewrites below: we can
+		// rewrite lookup(var.foo, "bar") but not lookup("${var.foo}", "bar").
+		call, argsChanged := c.callArgs(tokens)
+		if c.opts.OnlyInterpolations {
+			return call, argsChanged
+		}
+		if c.opts.ElementCalls {
+			if cleaned, changed := c.elementCall(call); changed {
+				return cleaned, true
+			}
+		}
+		if cleaned, changed := c.lookupCall(call); changed {
+			return cleaned, true
+		}
+		if c.opts.CollectionCalls {
+			if cleaned, changed := c.listCall(call); changed {
+				return cleaned, true
+			}
+			if cleaned, changed := c.mapCall(call); changed {
+				return cleaned, true
+			}
+		}
+		return call, argsChanged
+	}
+	return tokens, false
+}
+
+// callArgs cleans each of the argument expressions in the given function
+// call expression, such as join(",", ["${foo}", "${bar}"]).
+func (c *cleaner) callArgs(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	inside := tokens[2 : len(tokens)-1]
+	cleaned, changed := mapItems(inside, isCommaSep, c.valueExpr)
+	if !changed {
+		return tokens, false
+	}
+	ret := make(hclwrite.Tokens, 0, len(cleaned)+3)
+	ret = append(ret, tokens[:2]...)
+	ret = append(ret, cleaned...)
+	ret = append(ret, tokens[len(tokens)-1])
+	return ret, true
+}
+
+// templateExpr cleans the expression inside each of the interpolation
+// sequences in the given quoted template expression, such as
+// "prefix-${join(",", ["${foo}"])}", which can't itself be unwrapped.
+//
+// The contents of template directives, like %{ if ... }, are left
+// unchanged.
+func (c *cleaner) templateExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if parts := concatTemplateParts(tokens); parts != nil {
+		c.advise(describeConcat(parts))
+	}
+	ret := make(hclwrite.Tokens, 0, len(tokens))
+	changed := false
+	for i := 0; i < len(tokens); i++ {
+		token := tokens[i]
+		ret = append(ret, token)
+		if i == 0 || (token.Type != hclsyntax.TokenTemplateInterp && token.Type != hclsyntax.TokenTemplateControl) {
+			continue
+		}
+		end := closingIndex(tokens, i)
+		if end < 0 {
+			// Should never happen for tokens produced by a successful
+			// parse, but we'll leave the template alone if it does.
+			return tokens, false
+		}
+		if token.Type == hclsyntax.TokenTemplateInterp {
+			before, core, after := splitPadding(tokens[i+1 : end])
+			cleaned, exprChanged := c.valueExpr(core)
+			if exprChanged {
+				changed = true
+			}
+			ret = append(ret, before...)
+			ret = append(ret, cleaned...)
+			ret = append(ret, after...)
+		} else {
+			ret = append(ret, tokens[i+1:end]...)
+		}
+		ret = append(ret, tokens[end])
+		i = end
+	}
+	if !changed {
+		return tokens, false
+	}
+	return ret, true
+}
+
+// describeConcat returns an advisory message about a template consisting
+// only of the interpolations of the given expressions, as returned by
+// concatTemplateParts.
+func describeConcat(parts []hclwrite.Tokens) string {
+	names := make([]string, len(parts))
+	for i, part := range parts {
+		name := string(part.Bytes())
+		if len(name) > 40 || strings.ContainsAny(name, "\r\n") {
+			// This is just for a message, so we'll abbreviate any
+			// expressions that would make it hard to read.
+			name = "..."
+		}
+		names[i] = name
+	}
+	last := len(names) - 1
+	return fmt.Sprintf(
+		"template concatenating the interpolations of %s and %s, with no literal text between them, was left unchanged because it can't be unwrapped into a single expression",
+		strings.Join(names[:last], ", "), names[last],
+	)
+}
+
+// tupleExpr cleans each of the element expressions in the given tuple
+// constructor expression, such as ["${foo}", "${bar}"].
+//
+// A "for" expression also uses brackets, but its interior is not a sequence
+// of element expressions and so it is returned verbatim.
+func (c *cleaner) tupleExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	inside := tokens[1 : len(tokens)-1]
+	if isForExpr(inside) {
+		return tokens, false
+	}
+	cleaned, changed := mapItems(inside, isCommaSep, c.valueExpr)
+	if !changed {
+		return tokens, false
+	}
+	ret := make(hclwrite.Tokens, 0, len(cleaned)+2)
+	ret = append(ret, tokens[0])
+	ret = append(ret, cleaned...)
+	ret = append(ret, tokens[len(tokens)-1])
+	return ret, true
+}
+
+// objectExpr cleans each of the value expressions in the given object
+// constructor expression, such as { a = "${foo}", b = "${bar}" }.
+//
+// The keys are left untouched even if they are interpolations, because
+// unwrapping a key would change its meaning: { "${foo}" = 1 } uses the value
+// of foo as the key, while { foo = 1 } uses the literal string "foo".
+func (c *cleaner) objectExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	inside := tokens[1 : len(tokens)-1]
+	if isForExpr(inside) {
+		return tokens, false
+	}
+	cleaned, changed := mapItems(inside, isObjectItemSep, c.objectItem)
+	if !changed {
+		return tokens, false
+	}
+	ret := make(hclwrite.Tokens, 0, len(cleaned)+2)
+	ret = append(ret, tokens[0])
+	ret = append(ret, cleaned...)
+	ret = append(ret, tokens[len(tokens)-1])
+	return ret, true
+}
+
+// objectItem cleans the value part of a single "key = value" or
+// "key: value" item from an object constructor.
+func (c *cleaner) objectItem(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	depth := 0
+	for i, token := range tokens {
+		switch ty := token.Type; {
+		case opensNesting(ty):
+			depth++
+		case closesNesting(ty):
+			depth--
+		case depth == 0 && (ty == hclsyntax.TokenEqual || ty == hclsyntax.TokenColon):
+			value, changed := c.valueExpr(tokens[i+1:])
+			if !changed {
+				return tokens, false
+			}
+			ret := make(hclwrite.Tokens, 0, i+1+len(value))
+			ret = append(ret, tokens[:i+1]...)
+			ret = append(ret, value...)
+			return ret, true
+		}
+	}
+	// If we get here then we didn't find a key/value separator, which
+	// suggests we've misunderstood what we're looking at.
+	return tokens, false
+}
+
+// unwrapInterpolation checks whether the given tokens represent a template
+// consisting only of a single interpolation sequence, like "${foo}", and
+// if so returns the tokens representing the interpolated expression.
+//
+// Templates containing directives, like "%{ if foo }${bar}%{ endif }", are
+// never unwrapped, because their result depends on the directive.
+//
+// Heredoc templates are never unwrapped, even if they seem to contain only
+// a single interpolation sequence, because the newline before the closing
+// marker is part of the template. For example, the following is equivalent
+// to "${foo}\n", and not to foo:
+//
+//	<<EOT
+//	${foo}
+//	EOT
+func unwrapInterpolation(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if len(tokens) < 5 {
+		// Can't possibly be a "${ ... }" sequence without at least enough
+		// tokens for the delimiters and one token inside them.
+		return nil, false
+	}
+	oQuote := tokens[0]
+	oBrace := tokens[1]
+	cBrace := tokens[len(tokens)-2]
+	cQuote := tokens[len(tokens)-1]
+	if oQuote.Type != hclsyntax.TokenOQuote || oBrace.Type != hclsyntax.TokenTemplateInterp || cBrace.Type != hclsyntax.TokenTemplateSeqEnd || cQuote.Type != hclsyntax.TokenCQuote {
+		// Not an interpolation sequence at all, then.
+		return nil, false
+	}
+
+	inside := tokens[2 : len(tokens)-2]
+
+	// We're only interested in sequences that are provable to be single
+	// interpolation sequences, which we'll determine by hunting inside
+	// the interior tokens for any other interpolation sequences. This is
+	// likely to produce false negatives sometimes, but that's better than
+	// false positives and we're mainly interested in catching the easy cases
+	// here.
+	quotes := 0
+	for _, token := range inside {
+		if token.Type == hclsyntax.TokenOQuote {
+			quotes++
+			continue
+		}
+		if token.Type == hclsyntax.TokenCQuote {
+			quotes--
+			continue
+		}
+		if quotes > 0 {
+			// Interpolation sequences inside nested quotes are okay, because
+			// they are part of a nested expression.
+			// "${foo("${bar}")}"
+			continue
+		}
+		if token.Type == hclsyntax.TokenTemplateInterp || token.Type == hclsyntax.TokenTemplateControl || token.Type == hclsyntax.TokenTemplateSeqEnd {
+			// We've found another template delimiter within our interior
+			// tokens, which suggests that we've found something like this:
+			// "${foo}${bar}"
+			// or a template directive, like this:
+			// "${foo}%{ if bar }baz%{ endif }"
+			// That isn't unwrappable, so we'll leave the whole expression alone.
+			// The closing delimiter of the directive would also stop us
+			// here, but we check for the opening one too to be sure.
+			return nil, false
+		}
+	}
+
+	// If we got down here without an early return then this looks like
+	// an unwrappable sequence, but we'll trim any leading and trailing
+	// newlines that might result in an invalid result if we were to
+	// naively trim something like this:
+	// "${
+	//    foo
+	// }"
+	// We also trim any spaces before the expression, as in "${ foo }", so
+	// that the result is tidy even if the caller doesn't format it.
+	inside = trimSpace(inside)
+
+	// Newlines are allowed anywhere inside an interpolation sequence, but
+	// an argument value can only span multiple lines inside brackets, so
+	// we can't unwrap something like this:
+	// "${foo +
+	//    bar}"
+	// The same is true of a line comment, which includes its newline, and
+	// so we'll leave those alone rather than risk losing the comment.
+	if hasTopLevelNewline(inside) {
+		return nil, false
+	}
+	return inside, true
+}
+
+// providerExpr cleans the value of a "provider" argument, as described for
+// ProviderExpr.
+func (c *cleaner) providerExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if inside, ok := unwrapInterpolation(tokens); ok {
+		// Some configurations have a provider reference wrapped in an
+		// interpolation, like "${aws.foo}", which we can unwrap as long as
+		// the interpolated expression is a provider reference.
+		if !isProviderRef(inside) {
+			return tokens, false
+		}
+		c.stats.Interpolations++
+		return inside, true
+	}
+	if len(tokens) != 3 {
+		// We're only interested in plain quoted strings, which consist
+		// of the open and close quotes and a literal string token.
+		return tokens, false
+	}
+	oQuote := tokens[0]
+	strTok := tokens[1]
+	cQuote := tokens[2]
+	if oQuote.Type != hclsyntax.TokenOQuote || strTok.Type != hclsyntax.TokenQuotedLit || cQuote.Type != hclsyntax.TokenCQuote {
+		// Not a quoted string sequence, then.
+		return tokens, false
+	}
+	// HACK: Technically a provider.alias sequence ought to be three
+	// separate tokens, because the dot is an operator, but only the
+	// `Bytes` part of this is relevant to our output anyway so
+	// we'll cheat and thus avoid the need to parse `strTok.Bytes.
+	c.stats.ProviderRefs++
+	return hclwrite.Tokens{
+		{
+			Type:  hclsyntax.TokenIdent,
+			Bytes: []byte(strTok.Bytes),
+		},
+	}, true
+}
+
+// isProviderRef returns true if the given tokens represent a reference to
+// a provider configuration, like aws or aws.foo.
+func isProviderRef(tokens hclwrite.Tokens) bool {
+	switch len(tokens) {
+	case 1:
+		return tokens[0].Type == hclsyntax.TokenIdent
+	case 3:
+		return tokens[0].Type == hclsyntax.TokenIdent && tokens[1].Type == hclsyntax.TokenDot && tokens[2].Type == hclsyntax.TokenIdent
+	default:
+		return false
+	}
+}
+
+// typeExpr cleans the value of a "type" argument, as described for
+// TypeExpr.
+func (c *cleaner) typeExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if inside, ok := unwrapInterpolation(tokens); ok {
+		// Some configurations have a type constraint expression wrapped in
+		// an interpolation, like "${list(string)}", which we can unwrap as
+		// long as the interpolated expression does seem to be a type.
+		if !isTypeExpr(inside) {
+			c.advise("interpolation in type constraint was left unchanged because it doesn't seem to contain a type")
+			return tokens, false
+		}
+		c.stats.TypeConstraints++
+		return inside, true
+	}
+	if len(tokens) != 3 {
+		// We're only interested in plain quoted strings, which consist
+		// of the open and close quotes and a literal string token.
+		return tokens, false
+	}
+	oQuote := tokens[0]
+	strTok := tokens[1]
+	cQuote := tokens[2]
+	if oQuote.Type != hclsyntax.TokenOQuote || strTok.Type != hclsyntax.TokenQuotedLit || cQuote.Type != hclsyntax.TokenCQuote {
+		// Not a quoted string sequence, then.
+		return tokens, false
+	}
+
+	switch string(strTok.Bytes) {
+	case "string", "bool", "number", "any":
+		c.stats.TypeConstraints++
+		// The primitive type keywords, and the "any" placeholder, are the
+		// same as their legacy quoted forms, just without the quotes.
+		// Terraform 0.11 didn't support "any", but some configurations
+		// migrated by hand nonetheless use it in quotes by analogy.
+		return hclwrite.Tokens{
+			{
+				Type:  hclsyntax.TokenIdent,
+				Bytes: []byte(strTok.Bytes),
+			},
+		}, true
+	case "list":
+		c.stats.TypeConstraints++
+		return hclwrite.Tokens{
+			{
+				Type:  hclsyntax.TokenIdent,
+				Bytes: []byte("list"),
+			},
+			{
+				Type:  hclsyntax.TokenOParen,
+				Bytes: []byte("("),
+			},
+			{
+				Type:  hclsyntax.TokenIdent,
+				Bytes: []byte("string"),
+			},
+			{
+				Type:  hclsyntax.TokenCParen,
+				Bytes: []byte(")"),
+			},
+		}, true
+	case "map":
+		c.stats.TypeConstraints++
+		return hclwrite.Tokens{
+			{
+				Type:  hclsyntax.TokenIdent,
+				Bytes: []byte("map"),
+			},
+			{
+				Type:  hclsyntax.TokenOParen,
+				Bytes: []byte("("),
+			},
+			{
+				Type:  hclsyntax.TokenIdent,
+				Bytes: []byte("string"),
+			},
+			{
+				Type:  hclsyntax.TokenCParen,
+				Bytes: []byte(")"),
+			},
+		}, true
+	default:
+		// Something else we're not expecting, then.
+		c.advise(fmt.Sprintf("quoted type constraint %q was left unchanged because it isn't one of the legacy type names", strTok.Bytes))
+		return tokens, false
+	}
+}
+
+// isTypeExpr returns true if the given tokens seem to represent a type
+// constraint expression, like string or list(string).
+//
+// This only looks at the first token, which is enough to distinguish a type
+// constraint from the other kinds of expression that might appear in an
+// interpolation, such as a reference to a variable.
+func isTypeExpr(tokens hclwrite.Tokens) bool {
+	if len(tokens) == 0 || tokens[0].Type != hclsyntax.TokenIdent {
+		return false
+	}
+	switch string(tokens[0].Bytes) {
+	case "string", "number", "bool", "any", "list", "map", "set", "object", "tuple":
+		return true
+	default:
+		return false
+	}
+}
+
+// trimSpace removes any leading and trailing newline tokens from the given
+// tokens, and also removes any spaces before the first remaining token.
+//
+// The hclsyntax scanner doesn't produce tokens for spaces, and instead
+// records them in the SpacesBefore field of the following token, so
+// spaces after the last token are already excluded. To avoid modifying the
+// caller's tokens, the first token is copied before its spaces are removed.
+func trimSpace(tokens hclwrite.Tokens) hclwrite.Tokens {
+	tokens = trimNewlines(tokens)
+	if len(tokens) == 0 || tokens[0].SpacesBefore == 0 {
+		return tokens
+	}
+	first := *tokens[0]
+	first.SpacesBefore = 0
+	ret := make(hclwrite.Tokens, 0, len(tokens))
+	ret = append(ret, &first)
+	ret = append(ret, tokens[1:]...)
+	return ret
+}
+
+func trimNewlines(tokens hclwrite.Tokens) hclwrite.Tokens {
+	if len(tokens) == 0 {
+		return nil
+	}
+	var start, end int
+	for start = 0; start < len(tokens); start++ {
+		if tokens[start].Type != hclsyntax.TokenNewline {
+			break
+		}
+	}
+	for end = len(tokens); end > 0; end-- {
+		if tokens[end-1].Type != hclsyntax.TokenNewline {
+			break
+		}
+	}
+	return tokens[start:end]
+}
@@ -0,0 +1,923 @@
+package clean
+
+import (
+	"strings"
+	"testing"
+)
+
+// fixtures are examples of source code along with the expected result of
+// cleaning it with the given options. TestFixtures checks each of them, and
+// also checks that cleaning the result again doesn't change it any further.
+//
+// The source code and the expected result both start with a newline, for
+// readability, which is removed before using them.
+var fixtures = []struct {
+	name string
+	opts Options
+	src  string
+	want string
+}{
+	{
+		name: "already clean",
+		src: `
+a = b
+c = "d"
+`,
+		want: `
+a = b
+c = "d"
+`,
+	},
+	{
+		name: "single interpolation",
+		src: `
+a = "${b}"
+`,
+		want: `
+a = b
+`,
+	},
+	{
+		name: "spaces inside interpolation",
+		src: `
+a = "${  var.x  }"
+`,
+		want: `
+a = var.x
+`,
+	},
+	{
+		name: "interpolation with surrounding text",
+		src: `
+a = "x-${b}"
+`,
+		want: `
+a = "x-${b}"
+`,
+	},
+	{
+		name: "nested interpolations",
+		src: `
+a = "x-${join(",", ["${b}"])}"
+`,
+		want: `
+a = "x-${join(",", [b])}"
+`,
+	},
+	{
+		name: "tuple elements",
+		src: `
+a = ["${b}", "c"]
+`,
+		want: `
+a = [b, "c"]
+`,
+	},
+	{
+		name: "heredoc",
+		src: `
+a = <<EOT
+${b}
+EOT
+`,
+		want: `
+a = <<EOT
+${b}
+EOT
+`,
+	},
+	{
+		name: "quoted type constraint",
+		src: `
+variable "a" {
+  type = "string"
+}
+`,
+		want: `
+variable "a" {
+  type = string
+}
+`,
+	},
+	{
+		name: "quoted primitive type constraints",
+		src: `
+variable "a" {
+  type = "bool"
+}
+
+variable "b" {
+  type = "number"
+}
+`,
+		want: `
+variable "a" {
+  type = bool
+}
+
+variable "b" {
+  type = number
+}
+`,
+	},
+	{
+		name: "quoted any type constraint",
+		src: `
+variable "a" {
+  type = "any"
+}
+`,
+		want: `
+variable "a" {
+  type = any
+}
+`,
+	},
+	{
+		name: "unknown quoted type constraint",
+		src: `
+variable "a" {
+  type = "custom"
+}
+`,
+		want: `
+variable "a" {
+  type = "custom"
+}
+`,
+	},
+	{
+		name: "interpolated type constraint",
+		src: `
+variable "a" {
+  type = "${list(string)}"
+}
+
+variable "b" {
+  type = "${var.x}"
+}
+`,
+		want: `
+variable "a" {
+  type = list(string)
+}
+
+variable "b" {
+  type = "${var.x}"
+}
+`,
+	},
+	{
+		name: "quoted provider reference",
+		src: `
+resource "x" "y" {
+  provider = "aws.foo"
+}
+`,
+		want: `
+resource "x" "y" {
+  provider = aws.foo
+}
+`,
+	},
+	{
+		name: "nested block",
+		src: `
+resource "x" "y" {
+  a {
+    b = "${c}"
+  }
+}
+`,
+		want: `
+resource "x" "y" {
+  a {
+    b = c
+  }
+}
+`,
+	},
+	{
+		name: "element call left alone by default",
+		src: `
+a = element(var.azs, count.index)
+`,
+		want: `
+a = element(var.azs, count.index)
+`,
+	},
+	{
+		name: "element call with ElementCalls",
+		opts: Options{ElementCalls: true},
+		src: `
+a = element(var.azs, count.index)
+`,
+		want: `
+a = var.azs[count.index]
+`,
+	},
+	{
+		name: "two-argument lookup",
+		src: `
+a = lookup(var.m, "key")
+`,
+		want: `
+a = var.m["key"]
+`,
+	},
+	{
+		name: "three-argument lookup",
+		src: `
+a = lookup(var.m, "key", "default")
+`,
+		want: `
+a = lookup(var.m, "key", "default")
+`,
+	},
+	{
+		name: "three-argument lookup with interpolated arguments",
+		src: `
+a = "${lookup(var.m, "${var.k}", "default")}"
+`,
+		want: `
+a = lookup(var.m, var.k, "default")
+`,
+	},
+	{
+		name: "dynamic block content",
+		src: `
+resource "x" "y" {
+  dynamic "setting" {
+    for_each = "${var.settings}"
+    content {
+      name  = "${setting.key}"
+      value = "${setting.value}"
+    }
+  }
+}
+`,
+		want: `
+resource "x" "y" {
+  dynamic "setting" {
+    for_each = var.settings
+    content {
+      name  = setting.key
+      value = setting.value
+    }
+  }
+}
+`,
+	},
+	{
+		// Each block must be cleaned according to its own type, and not
+		// that of a sibling block visited before it.
+		name: "sibling blocks of different types",
+		src: `
+variable "a" {
+  type = "string"
+  validation {
+    condition = "${var.a != ""}"
+  }
+}
+resource "x" "y" {
+  provider = "aws.foo"
+  a {
+    type = "string"
+  }
+  b {
+    provider = "aws.bar"
+  }
+}
+data "x" "y" {
+  provider = "aws.baz"
+}
+`,
+		want: `
+variable "a" {
+  type = string
+  validation {
+    condition = var.a != ""
+  }
+}
+resource "x" "y" {
+  provider = aws.foo
+  a {
+    type = "string"
+  }
+  b {
+    provider = "aws.bar"
+  }
+}
+data "x" "y" {
+  provider = aws.baz
+}
+`,
+	},
+	{
+		name: "type argument of a resource",
+		src: `
+resource "aws_ssm_parameter" "x" {
+  type  = "String"
+  value = "${var.value}"
+}
+`,
+		want: `
+resource "aws_ssm_parameter" "x" {
+  type  = "String"
+  value = var.value
+}
+`,
+	},
+	{
+		name: "type argument in a nested block of a variable",
+		src: `
+variable "a" {
+  type = "map"
+  nested {
+    type = "string"
+  }
+}
+`,
+		want: `
+variable "a" {
+  type = map(string)
+  nested {
+    type = "string"
+  }
+}
+`,
+	},
+	{
+		name: "count and each references",
+		src: `
+resource "x" "y" {
+  index = "${count.index}"
+  key   = "${each.key}"
+  value = "${each.value}"
+}
+`,
+		want: `
+resource "x" "y" {
+  index = count.index
+  key   = each.key
+  value = each.value
+}
+`,
+	},
+	{
+		name: "conditional",
+		src: `
+a = "${var.enabled ? var.a : var.b}"
+`,
+		want: `
+a = var.enabled ? var.a : var.b
+`,
+	},
+	{
+		name: "conditional with nested templates",
+		src: `
+a = "${var.enabled ? "${var.a}-x" : "y"}"
+`,
+		want: `
+a = var.enabled ? "${var.a}-x" : "y"
+`,
+	},
+	{
+		name: "function call",
+		src: `
+a = "${join(",", var.list)}"
+`,
+		want: `
+a = join(",", var.list)
+`,
+	},
+	{
+		name: "arithmetic",
+		src: `
+a = "${var.count * 2 + 1}"
+`,
+		want: `
+a = var.count * 2 + 1
+`,
+	},
+	{
+		name: "several interpolations",
+		src: `
+a = "${var.a}${var.b}"
+b = "${var.a}-${var.b}"
+`,
+		want: `
+a = "${var.a}${var.b}"
+b = "${var.a}-${var.b}"
+`,
+	},
+	{
+		name: "locals",
+		src: `
+locals {
+  a = "${var.a}"
+  b = "${local.a}"
+  c = "${var.c ? local.a : local.b}"
+  d = {
+    "${local.a}" = "${local.b}"
+    (local.a)    = "x"
+  }
+  e = "${var.map[local.a]}"
+  f = var.list["${local.a}"]
+}
+`,
+		want: `
+locals {
+  a = var.a
+  b = local.a
+  c = var.c ? local.a : local.b
+  d = {
+    "${local.a}" = local.b
+    (local.a)    = "x"
+  }
+  e = var.map[local.a]
+  f = var.list["${local.a}"]
+}
+`,
+	},
+	{
+		// Unwrapping an interpolation that spans several lines removes
+		// the newlines around the expression, which would also remove any
+		// line comments, so those are left unchanged.
+		name: "comments inside interpolations",
+		src: `
+a = "${
+  # the thing
+  foo
+}"
+b = "${foo # trailing
+}"
+c = "${/* inline */ foo}"
+d = "${
+  foo
+}"
+`,
+		want: `
+a = "${
+  # the thing
+  foo
+}"
+b = "${foo # trailing
+}"
+c = /* inline */ foo
+d = foo
+`,
+	},
+	{
+		name: "list and map calls left alone by default",
+		src: `
+a = list("a", var.b)
+b = map("a", var.b)
+`,
+		want: `
+a = list("a", var.b)
+b = map("a", var.b)
+`,
+	},
+	{
+		name: "list and map calls with CollectionCalls",
+		opts: Options{CollectionCalls: true},
+		src: `
+a = list("a", var.b)
+b = map("a", var.b, var.k, 2)
+c = list()
+d = map()
+e = "${list(var.a, "${var.b}")}"
+`,
+		want: `
+a = ["a", var.b]
+b = { "a" = var.b, (var.k) = 2 }
+c = []
+d = {}
+e = [var.a, var.b]
+`,
+	},
+	{
+		name: "unusual list and map calls with CollectionCalls",
+		opts: Options{CollectionCalls: true},
+		src: `
+a = map("a")
+b = list(var.a...)
+`,
+		want: `
+a = map("a")
+b = list(var.a...)
+`,
+	},
+	{
+		name: "interpolated string literal",
+		src: `
+a = "${"foo"}"
+b = "${ "foo" }"
+c = "${"foo-${var.x}"}"
+`,
+		want: `
+a = "foo"
+b = "foo"
+c = "foo-${var.x}"
+`,
+	},
+	{
+		name: "test file",
+		src: `
+variables {
+  name = "${var.prefix}"
+}
+
+run "setup" {
+  variables {
+    count = "${length(var.list)}"
+  }
+
+  assert {
+    condition     = "${aws_instance.x.ami == var.ami}"
+    error_message = "wrong AMI"
+  }
+}
+`,
+		want: `
+variables {
+  name = var.prefix
+}
+
+run "setup" {
+  variables {
+    count = length(var.list)
+  }
+
+  assert {
+    condition     = aws_instance.x.ami == var.ami
+    error_message = "wrong AMI"
+  }
+}
+`,
+	},
+	{
+		name: "KeepWrapped",
+		opts: Options{KeepWrapped: []string{"policy", "type"}},
+		src: `
+variable "a" {
+  type    = "string"
+  default = "${var.b}"
+}
+resource "x" "y" {
+  policy = "${data.x.policy}"
+  other  = "${var.c}"
+  nested {
+    policy = "${var.d}"
+  }
+}
+`,
+		want: `
+variable "a" {
+  type    = "string"
+  default = var.b
+}
+resource "x" "y" {
+  policy = "${data.x.policy}"
+  other  = var.c
+  nested {
+    policy = "${var.d}"
+  }
+}
+`,
+	},
+	{
+		name: "redundant parentheses",
+		src: `
+a = "${(var.a)}"
+b = "${(var.a + var.b)}"
+c = "${(var.a + var.b) * 2}"
+`,
+		want: `
+a = var.a
+b = var.a + var.b
+c = (var.a + var.b) * 2
+`,
+	},
+	{
+		name: "meta-arguments",
+		src: `
+resource "x" "a" {
+  count      = "${length(var.list)}"
+  depends_on = ["${aws_instance.x}"]
+  provider   = "${aws.west}"
+}
+resource "x" "b" {
+  for_each   = "${var.map}"
+  depends_on = "${list(aws_instance.x)}"
+}
+module "m" {
+  source    = "./m"
+  providers = {
+    aws = "${aws.west}"
+  }
+}
+`,
+		want: `
+resource "x" "a" {
+  count      = length(var.list)
+  depends_on = [aws_instance.x]
+  provider   = aws.west
+}
+resource "x" "b" {
+  for_each   = var.map
+  depends_on = list(aws_instance.x)
+}
+module "m" {
+  source = "./m"
+  providers = {
+    aws = aws.west
+  }
+}
+`,
+	},
+	{
+		name: "version constraints",
+		src: `
+terraform {
+  required_version = ">= 0.12, < 0.14"
+  required_providers {
+    aws = "~> 3.0"
+    b = {
+      source  = "hashicorp/b"
+      version = ">= 1.0"
+    }
+  }
+}
+provider "aws" {
+  alias   = "west"
+  version = "~> 2.0"
+}
+`,
+		want: `
+terraform {
+  required_version = ">= 0.12, < 0.14"
+  required_providers {
+    aws = "~> 3.0"
+    b = {
+      source  = "hashicorp/b"
+      version = ">= 1.0"
+    }
+  }
+}
+provider "aws" {
+  alias   = "west"
+  version = "~> 2.0"
+}
+`,
+	},
+	{
+		name: "version constraints alongside other changes",
+		src: `
+terraform {
+  required_version = "~> 0.12.0"
+  required_providers {
+    aws = "~> 3.0"
+  }
+}
+provider "aws" {
+  alias   = "west"
+  version = "~> 2.0"
+  region  = "${var.region}"
+}
+`,
+		want: `
+terraform {
+  required_version = "~> 0.12.0"
+  required_providers {
+    aws = "~> 3.0"
+  }
+}
+provider "aws" {
+  alias   = "west"
+  version = "~> 2.0"
+  region  = var.region
+}
+`,
+	},
+	{
+		name: "template directives",
+		src: `
+a = "%{ if x }${y}%{ endif }"
+b = "%{ for v in var.l }${v}%{ endfor }"
+c = "%{ if x }${y}%{ else }${z}%{ endif }"
+`,
+		want: `
+a = "%{ if x }${y}%{ endif }"
+b = "%{ for v in var.l }${v}%{ endfor }"
+c = "%{ if x }${y}%{ else }${z}%{ endif }"
+`,
+	},
+	{
+		name: "template directives with nested interpolations",
+		src: `
+a = "${"%{if x}y%{endif}"}"
+b = "x-%{if "${x}"}${"${y}"}%{endif}"
+`,
+		want: `
+a = "%{if x}y%{endif}"
+b = "x-%{if "${x}"}${y}%{endif}"
+`,
+	},
+	{
+		name: "object on one line",
+		src: `
+a = { b = "${x}", c = "${y}" }
+`,
+		want: `
+a = { b = x, c = y }
+`,
+	},
+	{
+		name: "object on one line with trailing comma",
+		src: `
+a = { b = "${x}", c = "${y}", }
+`,
+		want: `
+a = { b = x, c = y, }
+`,
+	},
+	{
+		name: "object with newlines between entries",
+		src: `
+a = {
+  b = "${x}"
+  c = "${y}"
+}
+`,
+		want: `
+a = {
+  b = x
+  c = y
+}
+`,
+	},
+	{
+		name: "object with commas and newlines between entries",
+		src: `
+a = {
+  b = "${x}",
+  c = "${y}",
+}
+`,
+		want: `
+a = {
+  b = x,
+  c = y,
+}
+`,
+	},
+	{
+		name: "object with mixed separators and comments",
+		src: `
+a = {
+  b = "${x}", # first
+  c = "${y}"
+  d = "${z}" # last
+}
+`,
+		want: `
+a = {
+  b = x, # first
+  c = y
+  d = z # last
+}
+`,
+	},
+	{
+		name: "nested object",
+		src: `
+a = { b = { c = "${x}" } }
+`,
+		want: `
+a = { b = { c = x } }
+`,
+	},
+	{
+		name: "object with quoted and bare keys",
+		src: `
+a = { "k" = "${x}", k2 = "${y}" }
+`,
+		want: `
+a = { "k" = x, k2 = y }
+`,
+	},
+	{
+		name: "unary operators",
+		src: `
+a = "${-var.x}"
+b = "${!var.enabled}"
+c = ["${!var.a}", "${-var.b}"]
+d = "${!(var.a && var.b)}"
+`,
+		want: `
+a = -var.x
+b = !var.enabled
+c = [!var.a, -var.b]
+d = !(var.a && var.b)
+`,
+	},
+	{
+		name: "logical not elsewhere in a cleaned file",
+		src: `
+a = "${b}"
+c = var.d != var.e
+f = "!${g}" # ! here too
+`,
+		want: `
+a = b
+c = var.d != var.e
+f = "!${g}" # ! here too
+`,
+	},
+	{
+		name: "legacy splat left alone by default",
+		src: `
+a = "${aws_security_group.foo.*.id}"
+`,
+		want: `
+a = aws_security_group.foo.*.id
+`,
+	},
+	{
+		name: "legacy splat with Splats",
+		opts: Options{Splats: true},
+		src: `
+a = "${aws_security_group.foo.*.id}"
+b = foo.*.bar[0]
+`,
+		want: `
+a = aws_security_group.foo[*].id
+b = foo.*.bar[0]
+`,
+	},
+	{
+		name: "OnlyTypeConstraints",
+		opts: Options{OnlyTypeConstraints: true},
+		src: `
+a = "${x}"
+
+variable "v" {
+  type = "string"
+}
+`,
+		want: `
+a = "${x}"
+
+variable "v" {
+  type = string
+}
+`,
+	},
+	{
+		name: "OnlyInterpolations",
+		opts: Options{OnlyInterpolations: true},
+		src: `
+a = "${x}"
+
+variable "v" {
+  type = "string"
+}
+`,
+		want: `
+a = x
+
+variable "v" {
+  type = "string"
+}
+`,
+	},
+}
+
+func TestFixtures(t *testing.T) {
+	for _, fixture := range fixtures {
+		fixture := fixture
+		t.Run(fixture.name, func(t *testing.T) {
+			src := strings.TrimPrefix(fixture.src, "\n")
+			want := strings.TrimPrefix(fixture.want, "\n")
+			got, _, diags := fixture.opts.Bytes([]byte(src), "test.tf")
+			if diags.HasErrors() {
+				t.Fatalf("unexpected errors: %s", diags.Error())
+			}
+			if string(got) != want {
+				t.Fatalf("wrong result\ngot:\n%s\nwant:\n%s", got, want)
+			}
+
+			// A correct cleaner is idempotent, so cleaning the result again
+			// must not change it.
+			again, changed, diags := fixture.opts.Bytes(got, "test.tf")
+			if diags.HasErrors() {
+				t.Fatalf("unexpected errors when cleaning the result: %s", diags.Error())
+			}
+			if changed {
+				t.Fatalf("cleaning the result again changed it\nfirst:\n%s\nsecond:\n%s", got, again)
+			}
+		})
+	}
+}
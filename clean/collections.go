@@ -0,0 +1,118 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// listCall rewrites a call to the deprecated list function, like
+// list(a, b), into the equivalent tuple constructor, like [a, b].
+//
+// Strictly speaking the result is a tuple rather than a list, but Terraform
+// converts it to a list wherever a list is expected.
+func (c *cleaner) listCall(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	name, args, ok := functionCallArgs(tokens)
+	if !ok || name != "list" {
+		return tokens, false
+	}
+
+	ret := make(hclwrite.Tokens, 0, len(tokens)+1)
+	ret = append(ret, newToken(hclsyntax.TokenOBrack, "[", 0))
+	for i, arg := range args {
+		if i > 0 {
+			ret = append(ret, newToken(hclsyntax.TokenComma, ",", 0))
+			arg = withSpacesBefore(arg, 1)
+		} else {
+			arg = withSpacesBefore(arg, 0)
+		}
+		ret = append(ret, arg...)
+	}
+	ret = append(ret, newToken(hclsyntax.TokenCBrack, "]", 0))
+	c.stats.CollectionCalls++
+	return ret, true
+}
+
+// mapCall rewrites a call to the deprecated map function, like
+// map("a", b, "c", d), into the equivalent object constructor, like
+// { "a" = b, "c" = d }.
+//
+// Strictly speaking the result is an object rather than a map, but
+// Terraform converts it to a map wherever a map is expected. Any key that
+// isn't a literal string is wrapped in parentheses, so that it can't be
+// mistaken for a literal attribute name.
+func (c *cleaner) mapCall(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	name, args, ok := functionCallArgs(tokens)
+	if !ok || name != "map" || len(args)%2 != 0 {
+		return tokens, false
+	}
+	for _, arg := range args {
+		// The items of an object constructor can be separated by newlines,
+		// so a newline inside an argument would change its meaning.
+		if hasTopLevelNewline(arg) {
+			return tokens, false
+		}
+	}
+
+	ret := make(hclwrite.Tokens, 0, len(tokens)+len(args)+2)
+	ret = append(ret, newToken(hclsyntax.TokenOBrace, "{", 0))
+	for i := 0; i < len(args); i += 2 {
+		if i > 0 {
+			ret = append(ret, newToken(hclsyntax.TokenComma, ",", 0))
+		}
+		key, value := args[i], args[i+1]
+		if isStringLiteral(key) {
+			ret = append(ret, withSpacesBefore(key, 1)...)
+		} else {
+			ret = append(ret, newToken(hclsyntax.TokenOParen, "(", 1))
+			ret = append(ret, withSpacesBefore(key, 0)...)
+			ret = append(ret, newToken(hclsyntax.TokenCParen, ")", 0))
+		}
+		ret = append(ret, newToken(hclsyntax.TokenEqual, "=", 1))
+		ret = append(ret, withSpacesBefore(value, 1)...)
+	}
+	if len(args) == 0 {
+		ret = append(ret, newToken(hclsyntax.TokenCBrace, "}", 0))
+	} else {
+		ret = append(ret, newToken(hclsyntax.TokenCBrace, "}", 1))
+	}
+	c.stats.CollectionCalls++
+	return ret, true
+}
+
+// isStringLiteral returns true if the given tokens represent a quoted
+// string without any template sequences, like "foo".
+func isStringLiteral(tokens hclwrite.Tokens) bool {
+	if !isBracketed(tokens, hclsyntax.TokenOQuote) {
+		return false
+	}
+	for _, token := range tokens[1 : len(tokens)-1] {
+		if token.Type != hclsyntax.TokenQuotedLit {
+			return false
+		}
+	}
+	return true
+}
+
+// newToken returns a new token of the given type and content.
+func newToken(ty hclsyntax.TokenType, src string, spacesBefore int) *hclwrite.Token {
+	return &hclwrite.Token{
+		Type:         ty,
+		Bytes:        []byte(src),
+		SpacesBefore: spacesBefore,
+	}
+}
+
+// withSpacesBefore returns the given tokens with the given number of spaces
+// before the first token. As with trimSpace, the first token is copied so
+// that the caller's tokens are not modified.
+func withSpacesBefore(tokens hclwrite.Tokens, spaces int) hclwrite.Tokens {
+	if len(tokens) == 0 || tokens[0].SpacesBefore == spaces {
+		return tokens
+	}
+	first := *tokens[0]
+	first.SpacesBefore = spaces
+	ret := make(hclwrite.Tokens, 0, len(tokens))
+	ret = append(ret, &first)
+	ret = append(ret, tokens[1:]...)
+	return ret
+}
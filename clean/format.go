@@ -0,0 +1,59 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Format returns the given source code formatted in the same way as by
+// hclwrite.Format, except that there are no spaces between the logical not
+// operator and its operand.
+//
+// The version of hclwrite we use formats !foo as ! foo, unlike the later
+// versions used by terraform fmt, which would make the expressions that we
+// unwrap from interpolations, like "${!foo}", look odd. Format fixes that,
+// as does Bytes.
+func Format(src []byte) []byte {
+	return fixNotSpacing(hclwrite.Format(src))
+}
+
+// fixNotSpacing removes any spaces between each logical not operator in the
+// given source code and the token that follows it on the same line.
+func fixNotSpacing(src []byte) []byte {
+	tokens, diags := hclsyntax.LexConfig(src, "", hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		// Shouldn't happen, since src was produced by hclwrite, but we'll
+		// just leave it unchanged if so.
+		return src
+	}
+
+	var ret []byte
+	last := 0
+	for i := 0; i+1 < len(tokens); i++ {
+		if tokens[i].Type != hclsyntax.TokenBang {
+			continue
+		}
+		start := tokens[i].Range.End.Byte
+		end := tokens[i+1].Range.Start.Byte
+		if start == end || !isSpaces(src[start:end]) {
+			continue
+		}
+		ret = append(ret, src[last:start]...)
+		last = end
+	}
+	if ret == nil {
+		return src
+	}
+	return append(ret, src[last:]...)
+}
+
+// isSpaces returns true if the given bytes consist only of spaces and tabs.
+func isSpaces(b []byte) bool {
+	for _, c := range b {
+		if c != ' ' && c != '\t' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,82 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// Options enables the optional cleaning rules, which make changes that are
+// more opinionated than those that are always applied.
+//
+// The zero value of Options applies only the default rules, as used by the
+// package-level functions like File.
+type Options struct {
+	// Format enables formatting the result of Bytes even if none of the
+	// rules apply, as terraform fmt does.
+	Format bool
+
+	// Splats enables replacing legacy attribute-only splat expressions,
+	// like foo.*.id, with the equivalent full splat expressions, like
+	// foo[*].id.
+	Splats bool
+
+	// ElementCalls enables replacing calls to the element function, like
+	// element(foo, count.index), with the equivalent index syntax, like
+	// foo[count.index]. This is optional because element wraps around when
+	// given an index greater than the length of the list, while the index
+	// syntax instead produces an error.
+	ElementCalls bool
+
+	// CollectionCalls enables replacing calls to the deprecated list and
+	// map functions, like list(a, b), with the equivalent tuple and object
+	// constructors, like [a, b].
+	CollectionCalls bool
+
+	// OnlyTypeConstraints limits the cleaning to the type constraints of
+	// variables, leaving all other arguments unchanged.
+	OnlyTypeConstraints bool
+
+	// OnlyInterpolations limits the cleaning to unwrapping templates that
+	// consist only of a single interpolation, disabling all of the other
+	// rules including any optional ones enabled above.
+	OnlyInterpolations bool
+
+	// KeepWrapped lists the names of arguments that are always left
+	// unchanged, wherever they appear, such as for arguments of providers
+	// that historically required the "${...}" form.
+	KeepWrapped []string
+
+	// Transforms are additional rules to apply to the value of each
+	// argument, in order, after the built-in rules. They are not applied
+	// when OnlyTypeConstraints or OnlyInterpolations is set, or to the
+	// arguments named in KeepWrapped.
+	Transforms []Transform
+}
+
+// Transform is a custom cleaning rule, which is given the name and the
+// tokens of an argument's value and returns the rewritten tokens, along with
+// true if they differ from those given. The given tokens must not be
+// modified.
+//
+// A transform is given the value of every argument, in every block, so it
+// must leave alone any values that it doesn't recognize, returning false.
+// It can only rewrite the value, and not rename or remove the argument.
+type Transform func(name string, tokens hclwrite.Tokens) (hclwrite.Tokens, bool)
+
+// keepWrapped returns true if arguments with the given name should be left
+// unchanged, because the name is listed in KeepWrapped.
+func (o Options) keepWrapped(name string) bool {
+	for _, kept := range o.KeepWrapped {
+		if name == kept {
+			return true
+		}
+	}
+	return false
+}
+
+// File is like the package-level function File, but also applies the
+// optional rules enabled in the receiver.
+func (o Options) File(f *hclwrite.File) Stats {
+	c := cleaner{opts: o}
+	c.body(f.Body(), nil, nil)
+	return c.stats
+}
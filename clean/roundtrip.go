@@ -0,0 +1,58 @@
+package clean
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// unsafeRewriteSummary is the summary of the diagnostic returned by
+// checkRoundTrip, by which IsUnsafeRewrite recognizes it.
+const unsafeRewriteSummary = "Can't safely rewrite this file"
+
+// IsUnsafeRewrite returns true if the given diagnostics, as returned by
+// Bytes, report that the source code is valid but that the cleaned result
+// can't be written out safely, rather than that the source code is invalid.
+func IsUnsafeRewrite(diags hcl.Diagnostics) bool {
+	for _, diag := range diags {
+		if diag.Severity == hcl.DiagError && diag.Summary == unsafeRewriteSummary {
+			return true
+		}
+	}
+	return false
+}
+
+// checkRoundTrip returns an error diagnostic if the tokens of the given file
+// don't reproduce the given source code that it was parsed from, with the
+// given filename.
+//
+// The version of hclwrite we use misplaces the closing parenthesis of an
+// argument value that ends with a parenthesized expression, like (foo) or
+// a + (b), after the newline that ends the argument. That doesn't matter
+// if we leave the file unchanged, but the result would be invalid if we
+// were to write out the file with any changes, so we must detect it first.
+func checkRoundTrip(f *hclwrite.File, src []byte, filename string) hcl.Diagnostics {
+	got := f.BuildTokens(nil).Bytes()
+	if bytes.Equal(got, src) {
+		return nil
+	}
+
+	i := 0
+	for i < len(got) && i < len(src) && got[i] == src[i] {
+		i++
+	}
+	pos := hcl.Pos{
+		Line:   bytes.Count(src[:i], []byte("\n")) + 1,
+		Column: i - bytes.LastIndexByte(src[:i], '\n'),
+		Byte:   i,
+	}
+	return hcl.Diagnostics{
+		{
+			Severity: hcl.DiagError,
+			Summary:  unsafeRewriteSummary,
+			Detail:   "This argument value ends with a parenthesized expression, which can't be rewritten without corrupting the file. Remove the parentheses if they are redundant, or clean this file by hand.",
+			Subject:  &hcl.Range{Filename: filename, Start: pos, End: pos},
+		},
+	}
+}
@@ -0,0 +1,28 @@
+package clean
+
+import (
+	"testing"
+)
+
+func TestIsUnsafeRewrite(t *testing.T) {
+	tests := []struct {
+		name   string
+		src    string
+		unsafe bool
+	}{
+		{"needs cleaning", "a = \"${b}\"\nc = a + (b)\n", true},
+		{"already clean", "a = b\nc = a + (b)\n", false},
+		{"invalid", "a = \"${b}\"\nc = \n", false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, _, diags := Bytes([]byte(test.src), "test.tf")
+			if got := IsUnsafeRewrite(diags); got != test.unsafe {
+				t.Errorf("wrong result %t; want %t\ndiagnostics: %s", got, test.unsafe, diags.Error())
+			}
+			if test.unsafe && !diags.HasErrors() {
+				t.Errorf("no errors")
+			}
+		})
+	}
+}
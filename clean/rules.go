@@ -0,0 +1,108 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// rule is a cleaning rule that body applies to the value of each argument,
+// returning the cleaned tokens along with true if they differ from the given
+// tokens. A rule that doesn't apply to the given argument, with the options
+// of the given cleaner, returns false.
+type rule func(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool)
+
+// argument describes the argument whose value a rule is cleaning.
+//
+// inBlocks gives the types of the blocks that the argument is nested within,
+// as for body.
+type argument struct {
+	name     string
+	inBlocks []string
+}
+
+// isTypeConstraint returns true if the argument is the type constraint of
+// a variable.
+//
+// Only arguments directly inside a top-level block have special meaning. An
+// argument named "type" elsewhere, such as in a resource block or a
+// variable's nested validation block, is just a value.
+func (a argument) isTypeConstraint() bool {
+	return len(a.inBlocks) == 1 && a.inBlocks[0] == "variable" && a.name == "type"
+}
+
+// isProviderRef returns true if the argument is the provider reference of a
+// resource or data block.
+func (a argument) isProviderRef() bool {
+	return len(a.inBlocks) == 1 && (a.inBlocks[0] == "resource" || a.inBlocks[0] == "data") && a.name == "provider"
+}
+
+// builtinRules are the rules that body applies to every argument, in order,
+// before any of the custom Transforms.
+var builtinRules = []rule{
+	typeRule,
+	providerRule,
+	valueRule,
+	splatRule,
+}
+
+// rules returns the rules to apply to every argument, in order: the built-in
+// rules, followed by the custom Transforms.
+func (o Options) rules() []rule {
+	ret := make([]rule, 0, len(builtinRules)+len(o.Transforms))
+	ret = append(ret, builtinRules...)
+	for _, transform := range o.Transforms {
+		ret = append(ret, transformRule(transform))
+	}
+	return ret
+}
+
+// typeRule cleans the type constraint of a variable, as described for
+// TypeExpr.
+func typeRule(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if !arg.isTypeConstraint() || c.opts.OnlyInterpolations {
+		return tokens, false
+	}
+	return c.typeExpr(tokens)
+}
+
+// providerRule cleans the provider reference of a resource or data block,
+// as described for ProviderExpr.
+func providerRule(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if !arg.isProviderRef() || c.opts.OnlyInterpolations || c.opts.OnlyTypeConstraints {
+		return tokens, false
+	}
+	return c.providerExpr(tokens)
+}
+
+// valueRule cleans the value of any other argument, as described for
+// ValueExpr.
+func valueRule(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if arg.isTypeConstraint() || arg.isProviderRef() || c.opts.OnlyTypeConstraints {
+		return tokens, false
+	}
+	return c.valueExpr(tokens)
+}
+
+// splatRule replaces the legacy splat expressions in the value of any other
+// argument, if enabled by Options.Splats.
+func splatRule(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	if arg.isTypeConstraint() || arg.isProviderRef() || !c.opts.Splats || c.opts.OnlyTypeConstraints || c.opts.OnlyInterpolations {
+		return tokens, false
+	}
+	return c.splatExpr(tokens)
+}
+
+// transformRule returns a rule that applies the given custom transform, and
+// counts the changes it makes.
+func transformRule(transform Transform) rule {
+	return func(c *cleaner, arg argument, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+		if c.opts.OnlyTypeConstraints || c.opts.OnlyInterpolations {
+			return tokens, false
+		}
+		result, changed := transform(arg.name, tokens)
+		if !changed {
+			return tokens, false
+		}
+		c.stats.CustomTransforms++
+		return result, true
+	}
+}
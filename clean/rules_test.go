@@ -0,0 +1,105 @@
+package clean
+
+import (
+	"reflect"
+	"sort"
+	"strings"
+	"testing"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// renameTransform returns a transform that replaces each identifier with the
+// given name by the given replacement, and records the name of each argument
+// that it's given.
+func renameTransform(from, to string, names *[]string) Transform {
+	return func(name string, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+		*names = append(*names, name)
+		changed := false
+		ret := make(hclwrite.Tokens, len(tokens))
+		for i, token := range tokens {
+			if token.Type == hclsyntax.TokenIdent && string(token.Bytes) == from {
+				token = &hclwrite.Token{
+					Type:         token.Type,
+					Bytes:        []byte(to),
+					SpacesBefore: token.SpacesBefore,
+				}
+				changed = true
+			}
+			ret[i] = token
+		}
+		return ret, changed
+	}
+}
+
+func TestTransforms(t *testing.T) {
+	const src = `a = "${old.x}"
+b = "old"
+
+resource "x" "y" {
+  c          = [old.y, other]
+  keep       = old.z
+  provider   = "aws.west"
+}
+`
+	var firstNames, secondNames []string
+	opts := Options{
+		KeepWrapped: []string{"keep"},
+		Transforms: []Transform{
+			renameTransform("old", "middle", &firstNames),
+			renameTransform("middle", "new", &secondNames),
+		},
+	}
+	got, result, diags := opts.BytesResult([]byte(src), "test.tf")
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Error())
+	}
+
+	// The transforms run after the built-in rules, so they see the
+	// unwrapped interpolation, and in order, so the second sees the
+	// result of the first.
+	want := `a = new.x
+b = "old"
+
+resource "x" "y" {
+  c        = [new.y, other]
+  keep     = old.z
+  provider = aws.west
+}
+`
+	if string(got) != want {
+		t.Errorf("wrong result\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := result.Stats.CustomTransforms, 4; got != want {
+		t.Errorf("wrong CustomTransforms %d; want %d", got, want)
+	}
+
+	// Each transform is given every argument except those in KeepWrapped.
+	wantNames := []string{"a", "b", "c", "provider"}
+	for _, names := range [][]string{firstNames, secondNames} {
+		sort.Strings(names)
+		if !reflect.DeepEqual(names, wantNames) {
+			t.Errorf("wrong argument names %q; want %q", names, wantNames)
+		}
+	}
+}
+
+func TestTransformsOnlyInterpolations(t *testing.T) {
+	const src = "a = \"${old}\"\n"
+	var names []string
+	opts := Options{
+		OnlyInterpolations: true,
+		Transforms:         []Transform{renameTransform("old", "new", &names)},
+	}
+	got, _, diags := opts.Bytes([]byte(src), "test.tf")
+	if diags.HasErrors() {
+		t.Fatalf("unexpected errors: %s", diags.Error())
+	}
+	if got, want := string(got), "a = old\n"; got != want {
+		t.Errorf("wrong result\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if len(names) != 0 {
+		t.Errorf("transform was called for %s", strings.Join(names, ", "))
+	}
+}
@@ -0,0 +1,85 @@
+package clean
+
+import (
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// splatExpr replaces each legacy attribute-only splat operator in the given
+// expression, like the one in foo.*.id, with a full splat operator, like
+// foo[*].id.
+//
+// The two operators differ in how they treat any index operations that
+// follow them: foo.*.id[0] is the first id, whereas foo[*].id[0] is a list
+// of the first element of each id. We therefore only replace a splat
+// operator that is followed only by attribute accesses.
+func (c *cleaner) splatExpr(tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+	var ret hclwrite.Tokens
+	for i := 0; i < len(tokens); i++ {
+		if !isAttrSplat(tokens, i) {
+			if ret != nil {
+				ret = append(ret, tokens[i])
+			}
+			continue
+		}
+
+		if ret == nil {
+			// This is the first change, so we'll start building the
+			// result from a copy of the tokens so far.
+			ret = make(hclwrite.Tokens, i, len(tokens)+1)
+			copy(ret, tokens[:i])
+		}
+		ret = append(ret,
+			&hclwrite.Token{
+				Type:         hclsyntax.TokenOBrack,
+				Bytes:        []byte{'['},
+				SpacesBefore: tokens[i].SpacesBefore,
+			},
+			&hclwrite.Token{
+				Type:  hclsyntax.TokenStar,
+				Bytes: []byte{'*'},
+			},
+			&hclwrite.Token{
+				Type:  hclsyntax.TokenCBrack,
+				Bytes: []byte{']'},
+			},
+		)
+		c.stats.Splats++
+		i++ // skip the star, which we've already replaced
+	}
+	if ret == nil {
+		return tokens, false
+	}
+	return ret, true
+}
+
+// isAttrSplat returns true if the token at the given index is the start of
+// a legacy attribute-only splat operator that is followed only by attribute
+// accesses, and so can be replaced by a full splat operator.
+func isAttrSplat(tokens hclwrite.Tokens, i int) bool {
+	if i == 0 || i+1 >= len(tokens) {
+		return false
+	}
+	if tokens[i].Type != hclsyntax.TokenDot || tokens[i+1].Type != hclsyntax.TokenStar {
+		return false
+	}
+	switch tokens[i-1].Type {
+	case hclsyntax.TokenIdent, hclsyntax.TokenCBrack, hclsyntax.TokenCParen:
+	default:
+		return false
+	}
+
+	j := i + 2
+	for j+1 < len(tokens) && tokens[j].Type == hclsyntax.TokenDot && tokens[j+1].Type == hclsyntax.TokenIdent {
+		j += 2
+	}
+	if j < len(tokens) {
+		switch tokens[j].Type {
+		case hclsyntax.TokenDot, hclsyntax.TokenOBrack:
+			// Either an index operation or another splat operator, so the
+			// two forms wouldn't be equivalent.
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,70 @@
+package clean
+
+// Stats counts the changes made by the cleaning rules.
+type Stats struct {
+	// Interpolations is the number of templates consisting only of a single
+	// interpolation, like "${foo}", that were replaced by the interpolated
+	// expression.
+	Interpolations int `json:"interpolations"`
+
+	// TypeConstraints is the number of legacy quoted type constraints, like
+	// "string", that were replaced by type constraint expressions.
+	TypeConstraints int `json:"type_constraints"`
+
+	// ProviderRefs is the number of legacy quoted provider references, like
+	// "aws.foo", that were replaced by bare references.
+	ProviderRefs int `json:"provider_refs"`
+
+	// IndexCalls is the number of calls to the lookup function, and to the
+	// element function if enabled, that were replaced by the equivalent
+	// index syntax.
+	IndexCalls int `json:"index_calls"`
+
+	// Splats is the number of legacy attribute-only splat expressions, like
+	// foo.*.id, that were replaced by full splat expressions, like
+	// foo[*].id. This rule applies only if enabled in Options.
+	Splats int `json:"splats"`
+
+	// CollectionCalls is the number of calls to the deprecated list and map
+	// functions that were replaced by tuple and object constructors. This
+	// rule applies only if enabled in Options.
+	CollectionCalls int `json:"collection_calls"`
+
+	// CustomTransforms is the number of times one of the custom transforms
+	// given in Options changed the value of an argument.
+	CustomTransforms int `json:"custom_transforms"`
+}
+
+// Total returns the total number of changes of all kinds.
+func (s Stats) Total() int {
+	return s.Interpolations + s.TypeConstraints + s.ProviderRefs + s.IndexCalls + s.Splats + s.CollectionCalls + s.CustomTransforms
+}
+
+// Changed returns true if at least one change was made.
+func (s Stats) Changed() bool {
+	return s.Total() > 0
+}
+
+// Add adds the counts from the given stats to the receiver.
+func (s *Stats) Add(other Stats) {
+	s.Interpolations += other.Interpolations
+	s.TypeConstraints += other.TypeConstraints
+	s.ProviderRefs += other.ProviderRefs
+	s.IndexCalls += other.IndexCalls
+	s.Splats += other.Splats
+	s.CollectionCalls += other.CollectionCalls
+	s.CustomTransforms += other.CustomTransforms
+}
+
+// minus returns the difference between the receiver and the given stats.
+func (s Stats) minus(other Stats) Stats {
+	return Stats{
+		Interpolations:   s.Interpolations - other.Interpolations,
+		TypeConstraints:  s.TypeConstraints - other.TypeConstraints,
+		ProviderRefs:     s.ProviderRefs - other.ProviderRefs,
+		IndexCalls:       s.IndexCalls - other.IndexCalls,
+		Splats:           s.Splats - other.Splats,
+		CollectionCalls:  s.CollectionCalls - other.CollectionCalls,
+		CustomTransforms: s.CustomTransforms - other.CustomTransforms,
+	}
+}
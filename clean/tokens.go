@@ -0,0 +1,365 @@
+package clean
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// opensNesting returns true if the given token type begins a nested
+// sequence that will be ended by a token for which closesNesting is true.
+func opensNesting(ty hclsyntax.TokenType) bool {
+	switch ty {
+	case hclsyntax.TokenOParen, hclsyntax.TokenOBrack, hclsyntax.TokenOBrace,
+		hclsyntax.TokenOQuote, hclsyntax.TokenOHeredoc,
+		hclsyntax.TokenTemplateInterp, hclsyntax.TokenTemplateControl:
+		return true
+	default:
+		return false
+	}
+}
+
+// closesNesting returns true if the given token type ends a nested sequence
+// that was started by a token for which opensNesting is true.
+func closesNesting(ty hclsyntax.TokenType) bool {
+	switch ty {
+	case hclsyntax.TokenCParen, hclsyntax.TokenCBrack, hclsyntax.TokenCBrace,
+		hclsyntax.TokenCQuote, hclsyntax.TokenCHeredoc,
+		hclsyntax.TokenTemplateSeqEnd:
+		return true
+	default:
+		return false
+	}
+}
+
+// closingIndex returns the index of the token that closes the nested
+// sequence opened by the token at the given index, or -1 if the sequence
+// is not closed within the given tokens.
+//
+// The tokens we work with have all been produced by a successful parse, so
+// we can assume that the opening and closing tokens are properly balanced.
+func closingIndex(tokens hclwrite.Tokens, open int) int {
+	depth := 0
+	for i := open; i < len(tokens); i++ {
+		switch ty := tokens[i].Type; {
+		case opensNesting(ty):
+			depth++
+		case closesNesting(ty):
+			depth--
+			if depth == 0 {
+				return i
+			}
+		}
+	}
+	return -1
+}
+
+// isBracketed returns true if the given tokens are entirely enclosed by a
+// single pair of delimiters, the first of which has the given type.
+func isBracketed(tokens hclwrite.Tokens, open hclsyntax.TokenType) bool {
+	if len(tokens) < 2 || tokens[0].Type != open {
+		return false
+	}
+	return closingIndex(tokens, 0) == len(tokens)-1
+}
+
+// concatTemplateParts checks whether the given tokens, which should be a
+// quoted template, consist only of two or more interpolation sequences with
+// no literal text between them, like "${foo}${bar}", and if so returns the
+// tokens representing each of the interpolated expressions.
+//
+// A template with literal text between the interpolations, like
+// "${foo}-${bar}", is an ordinary template and so the result is nil.
+func concatTemplateParts(tokens hclwrite.Tokens) []hclwrite.Tokens {
+	if !isBracketed(tokens, hclsyntax.TokenOQuote) {
+		return nil
+	}
+	var parts []hclwrite.Tokens
+	for i := 1; i < len(tokens)-1; i++ {
+		if tokens[i].Type != hclsyntax.TokenTemplateInterp {
+			return nil
+		}
+		end := closingIndex(tokens, i)
+		if end < 0 {
+			return nil
+		}
+		parts = append(parts, trimSpace(tokens[i+1:end]))
+		i = end
+	}
+	if len(parts) < 2 {
+		return nil
+	}
+	return parts
+}
+
+// isForExpr returns true if the given tokens, which should be the interior
+// of a pair of brackets or braces, are the body of a "for" expression.
+func isForExpr(tokens hclwrite.Tokens) bool {
+	core, _, _ := splitPadding(tokens)
+	if len(core) == 0 {
+		return false
+	}
+	return core[0].Type == hclsyntax.TokenIdent && string(core[0].Bytes) == "for"
+}
+
+// mapItems splits the given tokens into top-level items separated by
+// tokens for which the given isSep function returns true, and returns a new
+// sequence of tokens where each item has been replaced by the result of
+// passing it to the given function. The separators themselves are retained.
+//
+// Each item is passed to the function without any leading or trailing
+// newlines or comments, and those are then restored around the result.
+// Empty items, such as after a trailing comma, are left untouched.
+//
+// The second return value is true if the function reported a change for
+// any of the items.
+func mapItems(tokens hclwrite.Tokens, isSep func(*hclwrite.Token) bool, fn func(hclwrite.Tokens) (hclwrite.Tokens, bool)) (hclwrite.Tokens, bool) {
+	ret := make(hclwrite.Tokens, 0, len(tokens))
+	changed := false
+	depth := 0
+	start := 0
+	for i := 0; i <= len(tokens); i++ {
+		if i < len(tokens) {
+			switch ty := tokens[i].Type; {
+			case opensNesting(ty):
+				depth++
+				continue
+			case closesNesting(ty):
+				depth--
+				continue
+			case depth > 0 || !isSep(tokens[i]):
+				continue
+			}
+		}
+
+		before, core, after := splitPadding(tokens[start:i])
+		if len(core) > 0 {
+			var itemChanged bool
+			core, itemChanged = fn(core)
+			if itemChanged {
+				changed = true
+			}
+		}
+		ret = append(ret, before...)
+		ret = append(ret, core...)
+		ret = append(ret, after...)
+		if i < len(tokens) {
+			ret = append(ret, tokens[i])
+		}
+		start = i + 1
+	}
+	return ret, changed
+}
+
+// isCommaSep returns true if the given token separates the elements of a
+// tuple constructor or the arguments of a function call.
+func isCommaSep(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenComma
+}
+
+// isObjectItemSep returns true if the given token separates the items of
+// an object constructor, which may be separated either by commas or by
+// newlines.
+//
+// A line comment includes its terminating newline, so it also counts as
+// a separator.
+func isObjectItemSep(token *hclwrite.Token) bool {
+	switch token.Type {
+	case hclsyntax.TokenComma, hclsyntax.TokenNewline:
+		return true
+	case hclsyntax.TokenComment:
+		return bytes.HasSuffix(token.Bytes, []byte{'\n'})
+	default:
+		return false
+	}
+}
+
+// splitPadding splits the given tokens into any leading newlines and
+// comments, the expression tokens between them, and any trailing newlines
+// and comments.
+func splitPadding(tokens hclwrite.Tokens) (before, core, after hclwrite.Tokens) {
+	start, end := 0, len(tokens)
+	for start < end && isPadding(tokens[start]) {
+		start++
+	}
+	for end > start && isPadding(tokens[end-1]) {
+		end--
+	}
+	return tokens[:start], tokens[start:end], tokens[end:]
+}
+
+func isPadding(token *hclwrite.Token) bool {
+	return token.Type == hclsyntax.TokenNewline || token.Type == hclsyntax.TokenComment
+}
+
+// isFunctionCall returns true if the given tokens represent a single
+// function call expression, like foo(a, b).
+func isFunctionCall(tokens hclwrite.Tokens) bool {
+	if len(tokens) < 3 || tokens[0].Type != hclsyntax.TokenIdent {
+		return false
+	}
+	return isBracketed(tokens[1:], hclsyntax.TokenOParen)
+}
+
+// functionCallArgs checks whether the given tokens represent a single
+// function call expression, like foo(a, b), and if so returns the name of the
+// function and the tokens for each of its arguments.
+//
+// This is conservative and will return false for function calls that use
+// the "..." expansion symbol or that have comments between the arguments,
+// so that callers that rewrite the call need not worry about those cases.
+func functionCallArgs(tokens hclwrite.Tokens) (string, []hclwrite.Tokens, bool) {
+	if !isFunctionCall(tokens) {
+		return "", nil, false
+	}
+	name := string(tokens[0].Bytes)
+	inside := tokens[2 : len(tokens)-1]
+
+	var args []hclwrite.Tokens
+	depth := 0
+	start := 0
+	for i := 0; i <= len(inside); i++ {
+		if i < len(inside) {
+			switch ty := inside[i].Type; {
+			case opensNesting(ty):
+				depth++
+				continue
+			case closesNesting(ty):
+				depth--
+				continue
+			case depth == 0 && ty == hclsyntax.TokenEllipsis:
+				return "", nil, false
+			case depth > 0 || ty != hclsyntax.TokenComma:
+				continue
+			}
+		}
+
+		before, core, after := splitPadding(inside[start:i])
+		if hasComment(before) || hasComment(after) {
+			return "", nil, false
+		}
+		if len(core) == 0 {
+			if i < len(inside) {
+				// An empty argument before a comma is not valid, so we
+				// must be confused about something.
+				return "", nil, false
+			}
+			// Otherwise it's just the "argument" after a trailing comma.
+			break
+		}
+		args = append(args, core)
+		start = i + 1
+	}
+	return name, args, true
+}
+
+// isTraversal returns true if the given tokens represent a simple
+// traversal, like foo.bar[0].baz, optionally rooted in a function call
+// instead of an identifier, like foo(bar).baz.
+//
+// Splat operators are allowed too, but callers that add further traversal
+// steps must check for them using hasSplat, because the new steps would
+// then apply to each of the splatted elements.
+func isTraversal(tokens hclwrite.Tokens) bool {
+	if len(tokens) == 0 || tokens[0].Type != hclsyntax.TokenIdent {
+		return false
+	}
+	i := 1
+	if i < len(tokens) && tokens[i].Type == hclsyntax.TokenOParen {
+		end := closingIndex(tokens, i)
+		if end < 0 {
+			return false
+		}
+		i = end + 1
+	}
+	for i < len(tokens) {
+		switch tokens[i].Type {
+		case hclsyntax.TokenDot:
+			if i+1 >= len(tokens) {
+				return false
+			}
+			if next := tokens[i+1].Type; next != hclsyntax.TokenIdent && next != hclsyntax.TokenNumberLit && next != hclsyntax.TokenStar {
+				return false
+			}
+			i += 2
+		case hclsyntax.TokenOBrack:
+			end := closingIndex(tokens, i)
+			if end < 0 {
+				return false
+			}
+			i = end + 1
+		default:
+			return false
+		}
+	}
+	return true
+}
+
+// hasSplat returns true if the given tokens contain a splat operator at
+// the top level, like foo.*.bar or foo[*].bar.
+func hasSplat(tokens hclwrite.Tokens) bool {
+	depth := 0
+	for i, token := range tokens {
+		switch ty := token.Type; {
+		case opensNesting(ty):
+			depth++
+			if ty == hclsyntax.TokenOBrack && depth == 1 && i+2 < len(tokens) && tokens[i+1].Type == hclsyntax.TokenStar && tokens[i+2].Type == hclsyntax.TokenCBrack {
+				return true
+			}
+		case closesNesting(ty):
+			depth--
+		case depth == 0 && ty == hclsyntax.TokenStar:
+			if i > 0 && tokens[i-1].Type == hclsyntax.TokenDot {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// stripParens removes any redundant parentheses enclosing the whole of the
+// given expression, like (foo), as long as the expression inside them can
+// be written without them. An argument value can only span multiple lines
+// inside brackets, so we keep the parentheses if there is a newline or a
+// line comment inside them.
+func stripParens(tokens hclwrite.Tokens) hclwrite.Tokens {
+	for isBracketed(tokens, hclsyntax.TokenOParen) {
+		inside := trimSpace(tokens[1 : len(tokens)-1])
+		if len(inside) == 0 || hasTopLevelNewline(inside) {
+			break
+		}
+		tokens = inside
+	}
+	return tokens
+}
+
+// hasTopLevelNewline returns true if the given tokens include either a
+// newline or a line comment that isn't nested inside any brackets.
+func hasTopLevelNewline(tokens hclwrite.Tokens) bool {
+	depth := 0
+	for _, token := range tokens {
+		switch ty := token.Type; {
+		case opensNesting(ty):
+			depth++
+		case closesNesting(ty):
+			depth--
+		case depth > 0:
+			continue
+		case ty == hclsyntax.TokenNewline:
+			return true
+		case ty == hclsyntax.TokenComment && bytes.HasSuffix(token.Bytes, []byte{'\n'}):
+			return true
+		}
+	}
+	return false
+}
+
+func hasComment(tokens hclwrite.Tokens) bool {
+	for _, token := range tokens {
+		if token.Type == hclsyntax.TokenComment {
+			return true
+		}
+	}
+	return false
+}
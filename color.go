@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+)
+
+// colorMode is set by the --color option, and is "auto" to color the diffs
+// and advisories we write when the output is a terminal, "always" to color
+// them regardless, or "never" to not color them at all. The --no-color
+// option is equivalent to "never".
+var colorMode string
+var noColor bool
+
+// The escape sequences we use to color our output.
+const (
+	colorReset  = "\x1b[0m"
+	colorBold   = "\x1b[1m"
+	colorRed    = "\x1b[31m"
+	colorGreen  = "\x1b[32m"
+	colorYellow = "\x1b[33m"
+	colorCyan   = "\x1b[36m"
+)
+
+// useColor returns true if we should color the output we write to the
+// given file.
+func useColor(f *os.File) bool {
+	switch colorMode {
+	case "always":
+		return true
+	case "never":
+		return false
+	default:
+		return isTerminal(f)
+	}
+}
+
+// colored returns the given text wrapped in the given color's escape
+// sequence, if enabled.
+func colored(enabled bool, color, text string) string {
+	if !enabled {
+		return text
+	}
+	return color + text + colorReset
+}
+
+// colorizeDiff returns a copy of the given unified diff with each line
+// colored according to its kind.
+func colorizeDiff(diff []byte) []byte {
+	var buf bytes.Buffer
+	for _, line := range bytes.SplitAfter(diff, []byte("\n")) {
+		if len(line) == 0 {
+			continue
+		}
+		content := bytes.TrimRight(line, "\r\n")
+		var color string
+		switch {
+		case bytes.HasPrefix(line, []byte("--- ")) || bytes.HasPrefix(line, []byte("+++ ")):
+			color = colorBold
+		case bytes.HasPrefix(line, []byte("@@")):
+			color = colorCyan
+		case bytes.HasPrefix(line, []byte("-")):
+			color = colorRed
+		case bytes.HasPrefix(line, []byte("+")):
+			color = colorGreen
+		default:
+			buf.Write(line)
+			continue
+		}
+		// We put the reset before the newline, so that the color doesn't
+		// leak into the start of the next line.
+		buf.WriteString(color)
+		buf.Write(content)
+		buf.WriteString(colorReset)
+		buf.Write(line[len(content):])
+	}
+	return buf.Bytes()
+}
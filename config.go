@@ -0,0 +1,67 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	flag "github.com/spf13/pflag"
+	"gopkg.in/yaml.v2"
+)
+
+// configFilename is the name of the optional configuration file that we
+// look for in the current working directory.
+const configFilename = ".terraform-clean-syntax.yml"
+
+// loadConfigFile reads the configuration file from the current working
+// directory, if present, and uses it to set any of the options that weren't
+// given on the command line.
+//
+// The keys in the configuration file are the names of the options, without
+// the leading dashes. Options that can be repeated, like exclude, may be
+// given as a list.
+func loadConfigFile() error {
+	src, err := ioutil.ReadFile(configFilename)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	var settings map[string]interface{}
+	if err := yaml.Unmarshal(src, &settings); err != nil {
+		return err
+	}
+
+	// We apply the settings in a consistent order, so that any errors
+	// are reported consistently.
+	names := make([]string, 0, len(settings))
+	for name := range settings {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	for _, name := range names {
+		f := flag.Lookup(name)
+		if f == nil {
+			return fmt.Errorf("unknown option %q", name)
+		}
+		if f.Changed {
+			// Options given on the command line take precedence.
+			continue
+		}
+
+		values, isList := settings[name].([]interface{})
+		if !isList {
+			values = []interface{}{settings[name]}
+		}
+		for _, v := range values {
+			if err := flag.Set(name, fmt.Sprint(v)); err != nil {
+				return fmt.Errorf("invalid value for %q: %s", name, err)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/pmezard/go-difflib/difflib"
+)
+
+// diffContext is the number of lines of context to include around each
+// change in diffs and patches, as given in the --diff-context option.
+var diffContext = 3
+
+// writeDiff writes a unified diff describing the difference between the
+// given old and new source code, labelled with the given old and new names
+// of the file.
+func writeDiff(w io.Writer, oldName, newName string, oldSrc, newSrc []byte) error {
+	diff := difflib.UnifiedDiff{
+		A:        diffLines(oldSrc),
+		B:        diffLines(newSrc),
+		FromFile: oldName,
+		ToFile:   newName,
+		Context:  diffContext,
+	}
+	return difflib.WriteUnifiedDiff(w, diff)
+}
+
+// writeStdoutDiff writes a unified diff describing the difference between
+// the given old and new source code of the file with the given name to
+// stdout, colored if enabled by --color. The caller must hold stdoutMu if
+// other goroutines might also be writing to stdout.
+func writeStdoutDiff(fn string, oldSrc, newSrc []byte) error {
+	if !useColor(os.Stdout) {
+		return writeDiff(os.Stdout, fn, fn, oldSrc, newSrc)
+	}
+	var buf bytes.Buffer
+	if err := writeDiff(&buf, fn, fn, oldSrc, newSrc); err != nil {
+		return err
+	}
+	_, err := os.Stdout.Write(colorizeDiff(buf.Bytes()))
+	return err
+}
+
+// diffLines splits the given source code into lines for diffing, retaining
+// the newline characters.
+//
+// This differs from difflib.SplitLines in that it doesn't produce a
+// spurious empty line at the end when the source ends with a newline, as
+// is the usual case for configuration files.
+func diffLines(src []byte) []string {
+	lines := strings.SplitAfter(string(src), "\n")
+	if last := lines[len(lines)-1]; last == "" {
+		lines = lines[:len(lines)-1]
+	} else {
+		// The diff output format requires that every line have a newline,
+		// so we'll add one to a final line that doesn't already have one.
+		lines[len(lines)-1] = last + "\n"
+	}
+	return lines
+}
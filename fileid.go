@@ -0,0 +1,13 @@
+package main
+
+// fileID uniquely identifies a file on the system, independently of the
+// path used to reach it.
+type fileID struct {
+	dev uint64
+	ino uint64
+}
+
+// visitedDirs tracks the directories we've already processed, so that we
+// can avoid processing the same directory twice if it's reachable via more
+// than one path.
+type visitedDirs map[fileID]struct{}
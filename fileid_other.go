@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"os"
+)
+
+// getFileID returns the unique identifier for the file described by the
+// given info, or false if no such identifier is available.
+//
+// On this platform the standard library doesn't expose a unique identifier
+// for files, so this always returns false.
+func getFileID(info os.FileInfo) (fileID, bool) {
+	return fileID{}, false
+}
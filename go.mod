@@ -3,6 +3,9 @@ module github.com/apparentlymart/terraform-clean-syntax
 go 1.12
 
 require (
+	github.com/bmatcuk/doublestar v1.3.4
 	github.com/hashicorp/hcl/v2 v2.5.1
+	github.com/pmezard/go-difflib v1.0.0
 	github.com/spf13/pflag v1.0.5
+	gopkg.in/yaml.v2 v2.4.0
 )
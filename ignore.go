@@ -0,0 +1,203 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// ignoreFile represents the patterns from a single file using the same
+// syntax as .gitignore files.
+type ignoreFile struct {
+	// dir is the directory containing the file, which the patterns are
+	// relative to, and name is the name of the file within it.
+	dir      string
+	name     string
+	patterns []ignorePattern
+}
+
+type ignorePattern struct {
+	glob    string
+	negate  bool
+	dirOnly bool
+}
+
+// cleanIgnoreFilename is the name of the ignore file specific to this
+// program, which we always respect in each directory we visit, whether or
+// not --respect-gitignore is set.
+const cleanIgnoreFilename = ".terraformcleanignore"
+
+// ignoreRules is a sequence of ignore files that apply to a particular
+// directory, ordered from the outermost directory to the innermost, so that
+// the patterns in later files take precedence.
+type ignoreRules []*ignoreFile
+
+// ignoreFilenames returns the names of the ignore files that we respect in
+// each directory, in order of increasing precedence.
+func ignoreFilenames() []string {
+	// Our own ignore file comes after .gitignore so that its patterns take
+	// precedence, allowing it to re-include files that git ignores.
+	if respectGitignore {
+		return []string{".gitignore", cleanIgnoreFilename}
+	}
+	return []string{cleanIgnoreFilename}
+}
+
+// withFilesIn returns the receiving rules followed by those of the ignore
+// files in the given directory, reporting any errors reading them.
+func (rules ignoreRules) withFilesIn(dir string) ignoreRules {
+	for _, name := range ignoreFilenames() {
+		ignoreFile, err := loadIgnoreFile(dir, name)
+		if err != nil {
+			reportError(dir, "Failed to read %s in %q: %s", name, dir, err)
+		}
+		if ignoreFile != nil {
+			// We use a full slice expression here so that appending
+			// can't overwrite the rules of a sibling directory.
+			rules = append(rules[:len(rules):len(rules)], ignoreFile)
+		}
+	}
+	return rules
+}
+
+// parentIgnoreRules returns the rules of the ignore files in the directories
+// above the given argument, up to the root of the git repository containing
+// it, so that they apply to the argument just as they would if we'd visited
+// it from the root. The result is empty if the argument isn't inside a git
+// repository, because then there is no clear place to stop.
+//
+// The directories of the resulting rules are absolute, because they may be
+// outside the current working directory.
+func parentIgnoreRules(arg string) ignoreRules {
+	abs, err := filepath.Abs(arg)
+	if err != nil || isRepoRoot(abs) {
+		return nil
+	}
+	var dirs []string
+	for dir := filepath.Dir(abs); ; dir = filepath.Dir(dir) {
+		dirs = append(dirs, dir)
+		if isRepoRoot(dir) {
+			break
+		}
+		if filepath.Dir(dir) == dir {
+			// We reached the root of the filesystem without finding one.
+			return nil
+		}
+	}
+	var ret ignoreRules
+	for i := len(dirs) - 1; i >= 0; i-- {
+		ret = ret.withFilesIn(dirs[i])
+	}
+	return ret
+}
+
+// isRepoRoot returns true if the given directory is the root of a git
+// repository, which is the case if it contains .git, which is a directory in
+// most repositories but a file in worktrees and submodules.
+func isRepoRoot(dir string) bool {
+	_, err := os.Lstat(filepath.Join(dir, ".git"))
+	return err == nil
+}
+
+// loadIgnoreFile reads the file with the given name in the given directory,
+// if it exists. If it does not exist, the result is nil with no error.
+func loadIgnoreFile(dir, name string) (*ignoreFile, error) {
+	f, err := os.Open(filepath.Join(dir, name))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	ret := &ignoreFile{dir: dir, name: name}
+	sc := bufio.NewScanner(f)
+	for sc.Scan() {
+		if pattern, ok := parseIgnorePattern(sc.Text()); ok {
+			ret.patterns = append(ret.patterns, pattern)
+		}
+	}
+	if err := sc.Err(); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
+
+// parseIgnorePattern parses a single line from an ignore file, returning
+// false if the line is blank or a comment.
+func parseIgnorePattern(line string) (ignorePattern, bool) {
+	var ret ignorePattern
+
+	line = strings.TrimRight(line, " \t\r")
+	switch {
+	case line == "" || strings.HasPrefix(line, "#"):
+		return ret, false
+	case strings.HasPrefix(line, "!"):
+		ret.negate = true
+		line = line[1:]
+	case strings.HasPrefix(line, `\#`), strings.HasPrefix(line, `\!`):
+		line = line[1:]
+	}
+
+	if strings.HasSuffix(line, "/") {
+		ret.dirOnly = true
+		line = strings.TrimRight(line, "/")
+	}
+	if line == "" {
+		return ret, false
+	}
+
+	// A pattern containing a slash is relative to the directory containing
+	// the ignore file, while one without matches at any level below it.
+	if strings.Contains(line, "/") {
+		line = strings.TrimPrefix(line, "/")
+	} else {
+		line = "**/" + line
+	}
+	ret.glob = line
+	return ret, true
+}
+
+// ignoredBy returns the name of the ignore file that causes the given path,
+// which must be within the directories of all of the receiving rules, to be
+// ignored, or an empty string if it isn't ignored.
+func (rules ignoreRules) ignoredBy(fn string, isDir bool) string {
+	ignoredBy := ""
+	absFn := ""
+	for _, file := range rules {
+		target := fn
+		if filepath.IsAbs(file.dir) && !filepath.IsAbs(fn) {
+			// The directories of the rules from parentIgnoreRules are
+			// absolute, so we must compare them with an absolute path.
+			if absFn == "" {
+				var err error
+				if absFn, err = filepath.Abs(fn); err != nil {
+					continue
+				}
+			}
+			target = absFn
+		}
+		rel, err := filepath.Rel(file.dir, target)
+		if err != nil {
+			continue
+		}
+		rel = filepath.ToSlash(rel)
+		for _, pattern := range file.patterns {
+			if pattern.dirOnly && !isDir {
+				continue
+			}
+			if matched, _ := doublestar.Match(pattern.glob, rel); matched {
+				if pattern.negate {
+					ignoredBy = ""
+				} else {
+					ignoredBy = file.name
+				}
+			}
+		}
+	}
+	return ignoredBy
+}
@@ -0,0 +1,56 @@
+package main
+
+import (
+	"bytes"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+	"github.com/hashicorp/hcl/v2/hclwrite"
+)
+
+// defaultIndent is the number of spaces that hclwrite uses for each level
+// of indentation.
+const defaultIndent = 2
+
+// indent is the number of spaces to use for each level of indentation in
+// the files we modify, set by the --indent option.
+var indent int
+
+// reindent changes the indentation of the given source code, which must
+// already be formatted by hclwrite, to use the given number of spaces for
+// each level.
+//
+// The content of heredoc templates is left unchanged, because its
+// indentation is part of the resulting string.
+func reindent(src []byte, fn string, spaces int) []byte {
+	f, diags := hclwrite.ParseConfig(src, fn, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		// Shouldn't happen, since src was produced by hclwrite, but we'll
+		// just leave it unchanged if so.
+		return src
+	}
+	tokens := f.BuildTokens(nil)
+	if !bytes.Equal(tokens.Bytes(), src) {
+		// The version of hclwrite we use misplaces the closing parenthesis
+		// of an argument value that ends with a parenthesized expression,
+		// so we can't rely on the tokens in that case and will leave the
+		// indentation unchanged.
+		return src
+	}
+	lineStart := true
+	inHeredoc := false
+	for _, token := range tokens {
+		if lineStart && !inHeredoc {
+			token.SpacesBefore = token.SpacesBefore / defaultIndent * spaces
+		}
+		switch token.Type {
+		case hclsyntax.TokenOHeredoc:
+			inHeredoc = true
+		case hclsyntax.TokenCHeredoc:
+			inHeredoc = false
+		}
+		n := len(token.Bytes)
+		lineStart = n > 0 && token.Bytes[n-1] == '\n'
+	}
+	return tokens.Bytes()
+}
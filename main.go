@@ -2,312 +2,777 @@ package main
 
 import (
 	"bytes"
+	"context"
 	"fmt"
 	"io/ioutil"
 	"log"
 	"os"
+	"os/signal"
 	"path/filepath"
+	"runtime"
 	"runtime/debug"
+	"sort"
 	"strings"
+	"sync"
+	"sync/atomic"
 
 	"github.com/hashicorp/hcl/v2"
-	"github.com/hashicorp/hcl/v2/hclsyntax"
-	"github.com/hashicorp/hcl/v2/hclwrite"
 	flag "github.com/spf13/pflag"
+
+	"github.com/apparentlymart/terraform-clean-syntax/clean"
+)
+
+// These are the exit codes that the program may return.
+const (
+	// exitSuccess means that all files were either already clean or were
+	// cleaned successfully.
+	exitSuccess = 0
+
+	// exitUsage means that the program was run with invalid arguments.
+	exitUsage = 1
+
+	// exitError means that there was at least one I/O or parse error
+	// while processing the files.
+	exitError = 2
+
+	// exitUnclean means that check mode found at least one file that
+	// needs cleaning, or that --fail-on-change mode changed at least one
+	// file.
+	exitUnclean = 3
 )
 
+// writeMode is set by the --write option, or its alias --in-place, in which
+// case we rewrite the files we clean in place. Otherwise we only preview the
+// changes.
+var writeMode bool
+
+// failOnChange is set by the --fail-on-change option, in which case we
+// exit with exitUnclean if we rewrote any files.
+var failOnChange bool
+
+// stdoutMode is set by the --stdout option, in which case we print the
+// cleaned source code of a single file to stdout rather than writing it back
+// to the file.
+var stdoutMode bool
+
+// checkMode is set by the --check option, in which case we only report
+// which files would change, rather than actually changing them.
+var checkMode bool
+
+// diffMode is set by the --diff option, in which case we print a diff of
+// the changes we would make, rather than actually changing any files.
+var diffMode bool
+
+// listMode is set by the --list option, in which case we print the names of
+// the files that would change to stdout, rather than actually changing them.
+var listMode bool
+
+// previewMode is set by the --preview option, in which case we print the
+// name of each file that would change to stdout along with the number of
+// changes, rather than actually changing them.
+var previewMode bool
+
+// backupMode is set by the --backup option, in which case we save the
+// original content of each file we modify in a sibling file with the
+// suffix ".bak".
+var backupMode bool
+
+// preserveMtime is set by the --preserve-mtime option, in which case each
+// file we rewrite keeps its original modification time, for the benefit of
+// tools that use it to decide what has changed.
+var preserveMtime bool
+
+// verboseMode is set by the --verbose option, in which case we log each
+// file we change, rather than just a summary at the end.
+var verboseMode bool
+
+// quietMode is set by the --quiet option, in which case we log only errors.
+var quietMode bool
+
+// forceMode is set by the --force option, in which case we process any file
+// given directly as an argument, even if its name doesn't have one of the
+// usual suffixes.
+var forceMode bool
+
+// extraSuffixes are the filename suffixes given in --ext options, which we
+// process in addition to the usual cleanableSuffixes.
+var extraSuffixes []string
+
+// excludePatterns are the glob patterns given in --exclude options. Any file
+// or directory whose path matches one of these is skipped.
+var excludePatterns []string
+
+// includePatterns are the glob patterns given in --include options. If there
+// are any, we process only the files whose paths match at least one of them.
+var includePatterns []string
+
+// recursive is set by the --recursive option, which is enabled by default.
+// If it is disabled, we process only the files directly inside each
+// directory given as an argument, and not those in its subdirectories.
+var recursive bool
+
+// maxDepth is set by the --max-depth option, and is the number of levels of
+// subdirectories to visit below each directory given as an argument. It is
+// negative if there is no limit.
+var maxDepth int
+
+// includeHidden is set by the --include-hidden option, in which case we
+// don't skip directories whose names start with a period.
+var includeHidden bool
+
+// followSymlinks is set by the --follow-symlinks option, in which case we
+// visit the files and directories that symbolic links refer to. By default,
+// symbolic links are skipped.
+var followSymlinks bool
+
+// verifyIdempotent is set by the --verify-idempotent option, in which case
+// we clean the result of cleaning each file a second time, and treat it as
+// an error if that would change it any further.
+var verifyIdempotent bool
+
+// respectGitignore is set by the --respect-gitignore option, in which case
+// we skip any files and directories that are ignored by .gitignore files in
+// the directories we visit.
+var respectGitignore bool
+
+// cleanOptions enables the optional cleaning rules, as selected by options
+// like --modernize-splats and --modernize-element. Its Format field is
+// set by the --format option, in which case we reformat every file we
+// process, even if none of the cleaning rules apply to it.
+var cleanOptions clean.Options
+
+// stdinFilename is set by the --stdin-filename option, and is the filename
+// we use to describe the source code when reading it from stdin.
+var stdinFilename string
+
+// filesFrom is set by the --files-from option, and is the name of a file
+// listing additional files to process, one per line, or "-" for stdin.
+var filesFrom string
+
+// maxFileSize is set by the --max-file-size option, and is the size in bytes
+// of the largest file we will process. It is zero if there is no limit.
+var maxFileSize int64
+
+// parallel is the number of files to process concurrently, set by the
+// --parallel option.
+var parallel int
+
+// filesProcessed and filesChanged count the files that processFile has
+// examined and the files that it has modified, respectively, so that we can
+// report a summary at the end.
+var filesProcessed, filesChanged int
+
+// filesInvalid counts the files that couldn't be parsed, and so couldn't be
+// cleaned.
+var filesInvalid int
+
+// filesUnsafe counts the files that are valid and need cleaning, but that
+// we couldn't clean because the result couldn't be written out safely.
+var filesUnsafe int
+
+// filesSkipped counts the files that we didn't clean because of a limit
+// such as --max-file-size, and so can't say whether they need cleaning.
+var filesSkipped int
+
+// uncleanFiles records the files that processFile found to need changes
+// while in check, list, or preview mode.
+var uncleanFiles []string
+
+// uncleanChanges records the number of changes needed for each of the files
+// in uncleanFiles.
+var uncleanChanges = make(map[string]int)
+
+// resultsMu must be held while accessing filesProcessed, filesChanged,
+// filesInvalid, filesUnsafe, filesSkipped, uncleanFiles, or
+// uncleanChanges, because processFile may run concurrently.
+var resultsMu sync.Mutex
+
+// stdoutMu must be held while writing to stdout during processFile, so that
+// the output for concurrent files isn't interleaved.
+var stdoutMu sync.Mutex
+
 func main() {
+	// By default the flag package exits with status 2 when it can't parse
+	// the arguments, but we reserve that for errors while processing files.
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
 	flag.Usage = func() {
-		os.Stderr.WriteString("Usage: terraform-clean-syntax <dir>\n")
+		os.Stderr.WriteString("Usage: terraform-clean-syntax [options] <dir>\n       terraform-clean-syntax [options] -\n\nOptions:\n")
+		flag.PrintDefaults()
 	}
+	flag.BoolVarP(&writeMode, "write", "w", false, "rewrite the files in place, rather than just previewing the changes")
+	flag.BoolVar(&writeMode, "in-place", false, "an alias for --write")
+	flag.BoolVar(&failOnChange, "fail-on-change", false, "with --write, exit with status 3 if any files were changed")
+	flag.BoolVar(&stdoutMode, "stdout", false, "print the cleaned content of the single file given as an argument to stdout")
+	flag.BoolVar(&checkMode, "check", false, "report files that need cleaning, without modifying them")
+	flag.BoolVar(&diffMode, "diff", false, "print a diff of the changes to make, without modifying any files")
+	flag.IntVar(&diffContext, "diff-context", diffContext, "the `number` of unchanged lines to show around each change with --diff or --output-patch")
+	flag.BoolVar(&listMode, "list", false, "print the names of files that need cleaning, without modifying them")
+	flag.BoolVar(&previewMode, "preview", false, "print the names of files that need cleaning and the number of changes to each, without modifying them")
+	flag.BoolVar(&backupMode, "backup", false, "save the original content of each modified file as <file>.bak")
+	flag.BoolVar(&preserveMtime, "preserve-mtime", false, "with --write, keep the original modification time of each file that is rewritten")
+	flag.BoolVar(&verboseMode, "verbose", false, "log the details of each file that is changed, and each file that is skipped")
+	flag.BoolVar(&recursive, "recursive", true, "also process the subdirectories of each directory; use --recursive=false to disable")
+	flag.IntVar(&maxDepth, "max-depth", -1, "visit at most the given `number` of levels of subdirectories; 0 means only the given directories themselves")
+	flag.BoolVar(&reportUnchanged, "report-unchanged", false, "log each file that doesn't need any changes, to confirm that it was checked")
+	flag.BoolVar(&quietMode, "quiet", false, "log only errors, and no informational messages or summary")
+	flag.BoolVar(&forceMode, "force", false, "process files given as arguments even if they don't have one of the usual suffixes, like .tf")
+	flag.BoolVar(&includeHidden, "include-hidden", false, "also process directories whose names start with a period")
+	flag.BoolVar(&followSymlinks, "follow-symlinks", false, "also visit the files and directories that symbolic links refer to, which are skipped by default")
+	flag.BoolVar(&respectGitignore, "respect-gitignore", false, "skip files and directories that are ignored by .gitignore files")
+	flag.StringArrayVar(&extraSuffixes, "ext", nil, "also process files whose names have the given `suffix`, like .hcl; can be repeated")
+	flag.StringArrayVar(&includePatterns, "include", nil, "process only the files whose path matches the given glob `pattern`; can be repeated")
+	flag.StringArrayVar(&excludePatterns, "exclude", nil, "skip files and directories whose path matches the given glob `pattern`; can be repeated")
+	flag.StringVar(&outputPatch, "output-patch", "", "write a patch describing the changes to the given `file`, without modifying any files")
+	flag.StringVar(&outputDir, "output-dir", "", "write the cleaned content of each file to the same relative path under the given `directory`, without modifying the originals")
+	flag.StringVar(&rootDir, "root", "", "report the paths of files relative to the given `directory`")
+	flag.BoolVar(&statsByDir, "stats-by-dir", false, "at the end, also log the number of files changed and the number of changes made in each directory")
+	flag.StringVar(&summaryFile, "summary-json", "", "also write a JSON summary of the results to the given `file`")
+	flag.BoolVar(&tarMode, "tar", false, "read a tar archive from stdin, given as -, and write a copy in which each file has been cleaned to stdout")
+	flag.BoolVar(&archiveMode, "archive", false, "treat each argument as a zip archive, and report the changes the files inside it need without modifying it")
+	flag.StringVar(&colorMode, "color", "auto", "`when` to color diffs and advisories: auto, to do so only when writing to a terminal, always, or never")
+	flag.BoolVar(&noColor, "no-color", false, "an alias for --color=never")
+	flag.BoolVar(&jsonMode, "json", false, "write a JSON description of the results to stdout, instead of logging them")
+	flag.StringVar(&stdinFilename, "stdin-filename", "<stdin>", "the `filename` to use in messages when reading from stdin")
+	flag.BoolVar(&cleanOptions.Splats, "modernize-splats", false, "also replace legacy splat expressions like foo.*.id with foo[*].id")
+	flag.BoolVar(&cleanOptions.ElementCalls, "modernize-element", false, "also replace calls to the element function like element(foo, count.index) with foo[count.index]")
+	flag.BoolVar(&cleanOptions.CollectionCalls, "modernize-collections", false, "also replace calls to the list and map functions with tuple and object constructors")
+	flag.StringArrayVar(&cleanOptions.KeepWrapped, "keep-wrapped", nil, "leave the arguments with the given `name` unchanged, wherever they appear; can be repeated")
+	flag.BoolVar(&cleanOptions.OnlyTypeConstraints, "only-types", false, "only convert the type constraints of variables, leaving all other arguments unchanged")
+	flag.BoolVar(&cleanOptions.OnlyInterpolations, "only-interps", false, "only unwrap interpolations, without making any other changes")
+	flag.BoolVar(&adviseMode, "advise", false, "at the end, list the legacy syntax in each file that was left unchanged because it can't be cleaned safely")
+	flag.BoolVar(&sortAttrs, "sort-attrs", false, "also sort the arguments in each block by name, keeping count, for_each, and provider first")
+	flag.BoolVar(&cleanOptions.Format, "format", false, "also reformat files that don't need cleaning, as terraform fmt does")
+	flag.IntVar(&indent, "indent", defaultIndent, "the `number` of spaces to use for each level of indentation in modified files")
+	flag.StringVar(&filesFrom, "files-from", "", "also process the files listed in the given `file`, one per line, or - to read the list from stdin")
+	flag.StringVar(&sinceRef, "since", "", "process only the files that git reports as changed since the given `ref`")
+	flag.Int64Var(&maxFileSize, "max-file-size", 0, "skip files larger than the given number of `bytes`; 0 means no limit")
+	flag.DurationVar(&perFileTimeout, "per-file-timeout", 0, "skip any file that takes longer than the given `duration` to clean, like 10s; 0 means no limit")
+	flag.BoolVar(&verifyIdempotent, "verify-idempotent", false, "check that cleaning each result a second time wouldn't change it further, for debugging")
+	flag.StringVar(&progressMode, "progress", "never", "`when` to periodically report the number of files processed to stderr: auto, to do so only if it's a terminal, always, or never")
+	flag.IntVar(&parallel, "parallel", runtime.NumCPU(), "the `number` of files to process concurrently")
 
-	flag.Parse()
+	if err := flag.CommandLine.Parse(os.Args[1:]); err != nil {
+		// For --help, the flag package has already printed the usage
+		// message, which is all that was asked for.
+		if err != flag.ErrHelp {
+			log.Print(err)
+			flag.Usage()
+		}
+		os.Exit(exitUsage)
+	}
+	if err := loadConfigFile(); err != nil {
+		log.Printf("Failed to load %s: %s", configFilename, err)
+		os.Exit(exitUsage)
+	}
 	args := flag.Args()
-	if len(args) < 1 {
+	if len(args) < 1 && filesFrom == "" {
 		flag.Usage()
-		os.Exit(1)
+		os.Exit(exitUsage)
+	}
+	if filesFrom != "" {
+		if len(args) == 1 && args[0] == "-" {
+			log.Printf("The --files-from option cannot be used when reading from stdin")
+			os.Exit(exitUsage)
+		}
+		listed, err := readFileList(filesFrom)
+		if err != nil {
+			log.Printf("Failed to read --files-from list: %s", err)
+			os.Exit(exitError)
+		}
+		args = append(args, listed...)
+	}
+	for _, pattern := range excludePatterns {
+		// The doublestar syntax is a superset of the filepath.Match syntax,
+		// so this will catch any malformed pattern.
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Printf("Invalid --exclude pattern %q: %s", pattern, err)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, pattern := range includePatterns {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			log.Printf("Invalid --include pattern %q: %s", pattern, err)
+			os.Exit(exitUsage)
+		}
+	}
+	for _, suffix := range extraSuffixes {
+		if !strings.HasPrefix(suffix, ".") {
+			suffix = "." + suffix
+		}
+		cleanableSuffixes = append(cleanableSuffixes, suffix)
+	}
+	if quietMode && verboseMode {
+		log.Printf("The --quiet and --verbose options cannot be used together")
+		os.Exit(exitUsage)
+	}
+	var err error
+	mode, err = chooseOutputMode(args)
+	if err != nil {
+		log.Print(err)
+		os.Exit(exitUsage)
+	}
+	if cleanOptions.OnlyTypeConstraints && cleanOptions.OnlyInterpolations {
+		log.Printf("The --only-types and --only-interps options cannot be used together")
+		os.Exit(exitUsage)
+	}
+	if indent < 1 {
+		log.Printf("Invalid --indent value %d: must be at least 1", indent)
+		os.Exit(exitUsage)
+	}
+	if diffContext < 0 {
+		log.Printf("Invalid --diff-context value %d: must not be negative", diffContext)
+		os.Exit(exitUsage)
+	}
+	if maxFileSize < 0 {
+		log.Printf("Invalid --max-file-size value %d: must not be negative", maxFileSize)
+		os.Exit(exitUsage)
+	}
+	if perFileTimeout < 0 {
+		log.Printf("Invalid --per-file-timeout value %s: must not be negative", perFileTimeout)
+		os.Exit(exitUsage)
+	}
+	if noColor {
+		colorMode = "never"
+	}
+	if colorMode != "auto" && colorMode != "always" && colorMode != "never" {
+		log.Printf("Invalid --color value %q: must be auto, always, or never", colorMode)
+		os.Exit(exitUsage)
+	}
+	if progressMode != "auto" && progressMode != "always" && progressMode != "never" {
+		log.Printf("Invalid --progress value %q: must be auto, always, or never", progressMode)
+		os.Exit(exitUsage)
+	}
+	if parallel < 1 {
+		log.Printf("Invalid --parallel value %d: must be at least 1", parallel)
+		os.Exit(exitUsage)
 	}
 
-	for _, arg := range args {
-		processItem(arg)
+	if sinceRef != "" {
+		if err := loadChangedFiles(sinceRef); err != nil {
+			log.Printf("Failed to find the files changed since %q: %s", sinceRef, err)
+			os.Exit(exitError)
+		}
 	}
-}
 
-func processItem(fn string) {
-	fn = filepath.Clean(fn)
+	if rootDir != "" && !(len(args) == 1 && args[0] == "-") {
+		if args, err = changeToRoot(args); err != nil {
+			log.Printf("Invalid --root directory %q: %s", rootDir, err)
+			os.Exit(exitUsage)
+		}
+	}
 
-	info, err := os.Lstat(fn)
-	if err != nil {
-		log.Printf("Failed to stat %q: %s\n", fn, err)
-		return
+	if tarMode {
+		processTar()
+	} else if len(args) == 1 && args[0] == "-" {
+		processStdin()
+	} else {
+		ctx := handleInterrupt()
+		stopProgress := startProgress()
+		processArgs(ctx, args)
+		stopProgress()
+		if ctx.Err() != nil {
+			reportError("", "Interrupted before all files were processed")
+		}
+		if mode == outputPatchFile {
+			if err := writePatchFile(); err != nil {
+				reportError(outputPatch, "Failed to write patch file %q: %s", outputPatch, err)
+			}
+		}
+		if jsonMode {
+			if err := writeJSONResults(os.Stdout); err != nil {
+				log.Printf("Failed to write JSON results: %s", err)
+				os.Exit(exitError)
+			}
+		} else if !quietMode {
+			logSummary()
+		}
 	}
 
-	if info.IsDir() {
-		if info.Name() != "." && info.Name() != ".." && strings.HasPrefix(info.Name(), ".") {
-			return
+	if summaryFile != "" {
+		if err := writeSummaryFile(); err != nil {
+			reportError(summaryFile, "Failed to write summary file %q: %s", summaryFile, err)
 		}
-		processDir(fn)
-	} else {
-		if !info.Mode().IsRegular() {
-			log.Printf("Skipping %q: not a regular file or directory", fn)
+	}
+	if adviseMode {
+		logAdvice()
+	}
+	if statsByDir {
+		logStatsByDir()
+	}
+
+	// Files are processed concurrently, so we'll sort them to make the
+	// result consistent.
+	sort.Strings(uncleanFiles)
+	if listMode {
+		for _, fn := range uncleanFiles {
+			fmt.Println(fn)
 		}
-		if !strings.HasSuffix(fn, ".tf") {
-			return
+	}
+	if previewMode {
+		for _, fn := range uncleanFiles {
+			fmt.Printf("%s: %d changes\n", fn, uncleanChanges[fn])
+		}
+	}
+	if checkMode && len(uncleanFiles) > 0 && !jsonMode && !listMode && !previewMode && !quietMode {
+		// In JSON, list, or preview mode, the output already described
+		// which files need cleaning. In quiet mode, the exit status is enough.
+		os.Stderr.WriteString("The following files need cleaning:\n")
+		for _, fn := range uncleanFiles {
+			fmt.Fprintf(os.Stderr, "  %s\n", fn)
 		}
-		processFile(fn, info.Mode())
+	}
+
+	switch {
+	case hadErrors:
+		os.Exit(exitError)
+	case checkMode && (len(uncleanFiles) > 0 || filesSkipped > 0):
+		// We can't confirm that a skipped file is clean, so we'll treat it
+		// as if it needs cleaning.
+		os.Exit(exitUnclean)
+	case failOnChange && filesChanged > 0:
+		os.Exit(exitUnclean)
+	default:
+		os.Exit(exitSuccess)
 	}
 }
 
-func processDir(fn string) {
-	entries, err := ioutil.ReadDir(fn)
-	if err != nil {
-		log.Printf("Failed to read directory %q: %s", fn, err)
-		return
+// handleInterrupt returns a context that is cancelled when the program
+// receives an interrupt signal, so that we can stop processing files and
+// still report what we've done so far. A second interrupt terminates the
+// program immediately.
+func handleInterrupt() context.Context {
+	ctx, cancel := context.WithCancel(context.Background())
+	interrupts := make(chan os.Signal, 1)
+	signal.Notify(interrupts, os.Interrupt)
+	go func() {
+		<-interrupts
+		signal.Stop(interrupts)
+		cancel()
+	}()
+	return ctx
+}
+
+// processArgs walks the files and directories given as arguments, processing
+// each of the files found using a pool of worker goroutines.
+//
+// If ctx is cancelled, processArgs returns early once the files already in
+// progress have been processed.
+func processArgs(ctx context.Context, args []string) {
+	queue := make(chan fileJob)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for job := range queue {
+				if ctx.Err() != nil {
+					continue
+				}
+				processFile(job.fn, job.root, job.info)
+				atomic.AddInt64(&filesDone, 1)
+			}
+		}()
+	}
+
+	var roots []string
+	for _, arg := range args {
+		fns, err := expandArg(arg)
+		if err != nil {
+			reportError(arg, "Invalid pattern %q: %s", arg, err)
+			continue
+		}
+		if len(fns) == 0 {
+			reportError(arg, "No files or directories match %q", arg)
+			continue
+		}
+		roots = append(roots, fns...)
 	}
+	outputFullPaths = len(roots) > 1
 
-	for _, entry := range entries {
-		processItem(filepath.Join(fn, entry.Name()))
+	w := newWalker(ctx, queue)
+	for _, fn := range roots {
+		if archiveMode {
+			processArchive(fn)
+			continue
+		}
+		// The ignore files in the directories above the argument apply
+		// to it too, including to the argument itself.
+		ignores := parentIgnoreRules(fn)
+		if info, err := os.Stat(fn); err == nil {
+			if ignoredBy := ignores.ignoredBy(fn, info.IsDir()); ignoredBy != "" {
+				logSkip(fn, "ignored by "+ignoredBy)
+				continue
+			}
+		}
+		w.root = fn
+		w.processItem(fn, ignores, 0)
 	}
+	close(queue)
+	wg.Wait()
 }
 
-func processFile(fn string, mode os.FileMode) {
+// processFile cleans the file with the given name, which was found by
+// visiting the given argument and is described by the given info.
+func processFile(fn, root string, info os.FileInfo) {
+	if maxFileSize > 0 && info.Size() > maxFileSize {
+		// Parsing reads the whole file into memory, so this guards against
+		// unexpectedly-large generated files.
+		reportSkipped(fn, "Skipping %q: larger than the maximum file size of %d bytes", fn, maxFileSize)
+		return
+	}
+
 	src, err := ioutil.ReadFile(fn)
 	if err != nil {
-		log.Printf("Failed to read file %q: %s", fn, err)
+		reportError(fn, "Failed to read file %q: %s", fn, err)
 		return
 	}
+	resultsMu.Lock()
+	filesProcessed++
+	resultsMu.Unlock()
 
-	defer func() {
-		if r := recover(); r != nil {
-			fmt.Printf("Recovered in processFile while processing %s: %#v\n%s", fn, r, debug.Stack())
-		}
-	}()
-
-	f, diags := hclwrite.ParseConfig(src, fn, hcl.Pos{Line: 1, Column: 1})
-	if diags.HasErrors() {
-		for _, diag := range diags {
-			if diag.Subject != nil {
-				log.Printf("[%s:%d] %s: %s", diag.Subject.Filename, diag.Subject.Start.Line, diag.Summary, diag.Detail)
-			} else {
-				log.Printf("%s: %s", diag.Summary, diag.Detail)
+	newSrc, stats, changes, ok := cleanSource(src, fn)
+	if !ok {
+		return
+	}
+	// cleanSource returns the original source when none of the rules
+	// applied, which is the common case for a configuration that has been
+	// cleaned before, so we can avoid comparing the content in that case.
+	if (!stats.Changed() && !cleanOptions.Format && !sortAttrs) || bytes.Equal(newSrc, src) {
+		// No changes
+		switch mode {
+		case outputStdout:
+			writeStdout(fn, src)
+		case outputDirectory:
+			// The output directory should contain a complete copy of the
+			// files we process, even those that were already clean.
+			if err := writeOutputFile(root, fn, src, info); err != nil {
+				reportError(fn, "Failed to write a copy of %q: %s", fn, err)
+				return
 			}
 		}
+		reportResult(fn, false, stats)
 		return
 	}
 
-	cleanFile(f)
+	if checkMode || listMode || previewMode {
+		resultsMu.Lock()
+		uncleanFiles = append(uncleanFiles, fn)
+		uncleanChanges[fn] = stats.Total()
+		resultsMu.Unlock()
+	}
 
-	newSrc := f.Bytes()
-	if bytes.Equal(newSrc, src) {
-		// No changes
+	switch mode {
+	case outputStdout:
+		writeStdout(fn, newSrc)
+	case outputDiff:
+		stdoutMu.Lock()
+		err := writeStdoutDiff(fn, src, newSrc)
+		stdoutMu.Unlock()
+		if err != nil {
+			reportError(fn, "Failed to write diff for %q: %s", fn, err)
+		}
+	case outputPatchFile:
+		if err := addPatch(fn, src, newSrc); err != nil {
+			reportError(fn, "Failed to generate patch for %q: %s", fn, err)
+		}
+	case outputDirectory:
+		if err := writeOutputFile(root, fn, newSrc, info); err != nil {
+			reportError(fn, "Failed to write the cleaned copy of %q: %s", fn, err)
+			return
+		}
+		resultsMu.Lock()
+		filesChanged++
+		resultsMu.Unlock()
+	}
+	if mode != outputInPlace {
+		reportResult(fn, true, stats)
 		return
 	}
 
-	// TODO: Write the new file to disk in place of the old one
-	err = ioutil.WriteFile(fn, newSrc, mode)
+	if backupMode {
+		// Backup files don't have the .tf suffix, so we'll never
+		// try to process them ourselves on a subsequent run.
+		backupFn := fn + ".bak"
+		err := ioutil.WriteFile(backupFn, src, info.Mode().Perm())
+		if err != nil {
+			reportError(fn, "Failed to write backup file %q: %s", backupFn, err)
+			return
+		}
+	}
+
+	err = writeFileAtomic(fn, newSrc, info)
 	if err != nil {
-		log.Printf("Failed to write to %q: %s", fn, err)
-		log.Printf("WARNING: File %q may be left with only partial content", fn)
+		reportError(fn, "Failed to write to %q: %s", fn, err)
 		return
 	}
-	log.Printf("Made changes: %s", fn)
+	resultsMu.Lock()
+	filesChanged++
+	resultsMu.Unlock()
+	reportResult(fn, true, stats)
+	if verboseMode && !jsonMode {
+		logChanges(fn, changes)
+	}
 }
 
-func cleanFile(f *hclwrite.File) {
-	cleanBody(f.Body(), nil)
+// writeStdout writes the given source code for the given file to stdout.
+func writeStdout(fn string, src []byte) {
+	stdoutMu.Lock()
+	_, err := os.Stdout.Write(src)
+	stdoutMu.Unlock()
+	if err != nil {
+		reportError(fn, "Failed to write %q to stdout: %s", fn, err)
+	}
 }
 
-func cleanBody(body *hclwrite.Body, inBlocks []string) {
-	attrs := body.Attributes()
-	for name, attr := range attrs {
-		var cleanedExprTokens hclwrite.Tokens
-		tokens := attr.Expr().BuildTokens(nil)
-		if len(inBlocks) == 1 {
-			inBlock := inBlocks[0]
-			if inBlock == "variable" && name == "type" {
-				cleanedExprTokens = cleanTypeExpr(tokens)
-				body.SetAttributeRaw(name, cleanedExprTokens)
-				continue
-			} else if (inBlock == "resource" || inBlock == "data") && name == "provider" {
-				cleanedExprTokens = cleanProviderExpr(tokens)
-				body.SetAttributeRaw(name, cleanedExprTokens)
-				continue
-			}
+// isRegularFile returns true if the given name refers to a regular file,
+// rather than a directory or anything else.
+func isRegularFile(fn string) bool {
+	info, err := os.Stat(fn)
+	return err == nil && info.Mode().IsRegular()
+}
+
+// processStdin reads configuration source from stdin and writes the cleaned
+// result to stdout, for use in pipelines such as editor integrations.
+func processStdin() {
+	fn := stdinFilename
+
+	src, err := ioutil.ReadAll(os.Stdin)
+	if err != nil {
+		reportError(fn, "Failed to read from stdin: %s", err)
+		os.Exit(exitError)
+	}
+
+	newSrc, stats, _, ok := cleanSource(src, fn)
+	if !ok {
+		// We mustn't produce any output in this case, or else a caller
+		// might mistake it for the cleaned source.
+		os.Exit(exitError)
+	}
+
+	if checkMode || listMode || previewMode {
+		if !bytes.Equal(newSrc, src) {
+			uncleanFiles = append(uncleanFiles, fn)
+			uncleanChanges[fn] = stats.Total()
 		}
-		cleanedExprTokens = cleanValueExpr(tokens)
-		body.SetAttributeRaw(name, cleanedExprTokens)
 	}
 
-	blocks := body.Blocks()
-	for _, block := range blocks {
-		inBlocks := append(inBlocks, block.Type())
-		cleanBody(block.Body(), inBlocks)
+	switch mode {
+	case outputStdout:
+		_, err = os.Stdout.Write(newSrc)
+	case outputDiff:
+		if !bytes.Equal(newSrc, src) {
+			err = writeStdoutDiff(fn, src, newSrc)
+		}
+	}
+	if err != nil {
+		reportError(fn, "Failed to write to stdout: %s", err)
+		os.Exit(exitError)
 	}
 }
 
-func cleanValueExpr(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) < 5 {
-		// Can't possibly be a "${ ... }" sequence without at least enough
-		// tokens for the delimiters and one token inside them.
-		return tokens
-	}
-	oQuote := tokens[0]
-	oBrace := tokens[1]
-	cBrace := tokens[len(tokens)-2]
-	cQuote := tokens[len(tokens)-1]
-	if oQuote.Type != hclsyntax.TokenOQuote || oBrace.Type != hclsyntax.TokenTemplateInterp || cBrace.Type != hclsyntax.TokenTemplateSeqEnd || cQuote.Type != hclsyntax.TokenCQuote {
-		// Not an interpolation sequence at all, then.
-		return tokens
-	}
-
-	inside := tokens[2 : len(tokens)-2]
-
-	// We're only interested in sequences that are provable to be single
-	// interpolation sequences, which we'll determine by hunting inside
-	// the interior tokens for any other interpolation sequences. This is
-	// likely to produce false negatives sometimes, but that's better than
-	// false positives and we're mainly interested in catching the easy cases
-	// here.
-	quotes := 0
-	for _, token := range inside {
-		if token.Type == hclsyntax.TokenOQuote {
-			quotes++
-			continue
+// cleanSource parses the given source code as a configuration file, applies
+// our cleaning rules to it, and returns the resulting source code along with
+// a description of the changes made. If none of the rules apply then the
+// result is the given source code, unchanged.
+//
+// In verbose mode, the result also describes the changes made to each
+// argument, so that we can log them.
+//
+// If the source code is invalid, cleanSource reports the error diagnostics
+// and returns false to indicate that the result is not usable. It also
+// returns false if applying the rules takes longer than --per-file-timeout.
+func cleanSource(src []byte, fn string) (newSrc []byte, stats clean.Stats, changes []clean.Change, ok bool) {
+	defer func() {
+		if r := recover(); r != nil {
+			fmt.Fprintf(os.Stderr, "Recovered in cleanSource while processing %s: %#v\n%s", fn, r, debug.Stack())
+			reportError(fn, "Internal error while processing %q: %v", fn, r)
+			newSrc, stats, changes, ok = nil, clean.Stats{}, nil, false
 		}
-		if token.Type == hclsyntax.TokenCQuote {
-			quotes--
-			continue
+	}()
+
+	newSrc, result, diags, finished := applyRulesTimeout(src, fn)
+	if !finished {
+		reportSkipped(fn, "Skipping %q: took longer than the --per-file-timeout of %s", fn, perFileTimeout)
+		return nil, clean.Stats{}, nil, false
+	}
+	if diags.HasErrors() {
+		reportDiagnostics(fn, bytes.TrimPrefix(src, utf8BOM), diags)
+		resultsMu.Lock()
+		if clean.IsUnsafeRewrite(diags) {
+			filesUnsafe++
+		} else {
+			filesInvalid++
 		}
-		if quotes > 0 {
-			// Interpolation sequences inside nested quotes are okay, because
-			// they are part of a nested expression.
-			// "${foo("${bar}")}"
-			continue
+		resultsMu.Unlock()
+		return nil, stats, nil, false
+	}
+	recordAdvice(fn, result.Advisories)
+
+	if verifyIdempotent && !bytes.Equal(newSrc, src) {
+		// Cleaning the result again should never change it, so if it does
+		// then one of our rules has a bug and we shouldn't trust the
+		// result at all.
+		again, _, diags, finished := applyRulesTimeout(newSrc, fn)
+		if !finished {
+			reportSkipped(fn, "Skipping %q: checking the result took longer than the --per-file-timeout of %s", fn, perFileTimeout)
+			return nil, clean.Stats{}, nil, false
+		}
+		if diags.HasErrors() {
+			reportError(fn, "Internal error while processing %q: the cleaned result is invalid: %s", fn, diags.Error())
+			return nil, clean.Stats{}, nil, false
 		}
-		if token.Type == hclsyntax.TokenTemplateInterp || token.Type == hclsyntax.TokenTemplateSeqEnd {
-			// We've found another template delimiter within our interior
-			// tokens, which suggests that we've found something like this:
-			// "${foo}${bar}"
-			// That isn't unwrappable, so we'll leave the whole expression alone.
-			return tokens
+		if !bytes.Equal(again, newSrc) {
+			reportError(fn, "Internal error while processing %q: cleaning the result again would change it further", fn)
+			return nil, clean.Stats{}, nil, false
 		}
 	}
-
-	// If we got down here without an early return then this looks like
-	// an unwrappable sequence, but we'll trim any leading and trailing
-	// newlines that might result in an invalid result if we were to
-	// naively trim something like this:
-	// "${
-	//    foo
-	// }"
-	return trimNewlines(inside)
+	return newSrc, result.Stats, result.Changes, true
 }
 
-func cleanProviderExpr(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) != 3 {
-		// We're only interested in plain quoted strings, which consist
-		// of the open and close quotes and a literal string token.
-		return tokens
-	}
-	oQuote := tokens[0]
-	strTok := tokens[1]
-	cQuote := tokens[2]
-	if oQuote.Type != hclsyntax.TokenOQuote || strTok.Type != hclsyntax.TokenQuotedLit || cQuote.Type != hclsyntax.TokenCQuote {
-		// Not a quoted string sequence, then.
-		return tokens
-	}
-	// HACK: Technically a provider.alias sequence ought to be three
-	// separate tokens, because the dot is an operator, but only the
-	// `Bytes` part of this is relevant to our output anyway so
-	// we'll cheat and thus avoid the need to parse `strTok.Bytes.
-	return hclwrite.Tokens{
-		{
-			Type:  hclsyntax.TokenIdent,
-			Bytes: []byte(strTok.Bytes),
-		},
+// applyRules is the main part of cleanSource, which applies the cleaning
+// rules to the given source code using clean.Options.BytesResult, along with
+// the adjustments selected by our other options, and returns the result
+// without reporting any errors.
+func applyRules(src []byte, fn string) ([]byte, clean.Result, hcl.Diagnostics) {
+	// Some editors save files with a leading byte order mark, which the
+	// HCL parser doesn't expect, so we'll strip it before parsing and then
+	// restore it in the result.
+	body := src
+	hasBOM := bytes.HasPrefix(src, utf8BOM)
+	if hasBOM {
+		body = src[len(utf8BOM):]
 	}
-}
 
-func cleanTypeExpr(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) != 3 {
-		// We're only interested in plain quoted strings, which consist
-		// of the open and close quotes and a literal string token.
-		return tokens
-	}
-	oQuote := tokens[0]
-	strTok := tokens[1]
-	cQuote := tokens[2]
-	if oQuote.Type != hclsyntax.TokenOQuote || strTok.Type != hclsyntax.TokenQuotedLit || cQuote.Type != hclsyntax.TokenCQuote {
-		// Not a quoted string sequence, then.
-		return tokens
-	}
-
-	switch string(strTok.Bytes) {
-	case "string":
-		return hclwrite.Tokens{
-			{
-				Type:  hclsyntax.TokenIdent,
-				Bytes: []byte("string"),
-			},
-		}
-	case "list":
-		return hclwrite.Tokens{
-			{
-				Type:  hclsyntax.TokenIdent,
-				Bytes: []byte("list"),
-			},
-			{
-				Type:  hclsyntax.TokenOParen,
-				Bytes: []byte("("),
-			},
-			{
-				Type:  hclsyntax.TokenIdent,
-				Bytes: []byte("string"),
-			},
-			{
-				Type:  hclsyntax.TokenCParen,
-				Bytes: []byte(")"),
-			},
-		}
-	case "map":
-		return hclwrite.Tokens{
-			{
-				Type:  hclsyntax.TokenIdent,
-				Bytes: []byte("map"),
-			},
-			{
-				Type:  hclsyntax.TokenOParen,
-				Bytes: []byte("("),
-			},
-			{
-				Type:  hclsyntax.TokenIdent,
-				Bytes: []byte("string"),
-			},
-			{
-				Type:  hclsyntax.TokenCParen,
-				Bytes: []byte(")"),
-			},
-		}
-	default:
-		// Something else we're not expecting, then.
-		return tokens
+	newSrc, result, diags := cleanOptions.BytesResult(body, fn)
+	if diags.HasErrors() {
+		return nil, clean.Result{}, diags
 	}
-}
 
-func trimNewlines(tokens hclwrite.Tokens) hclwrite.Tokens {
-	if len(tokens) == 0 {
-		return nil
-	}
-	var start, end int
-	for start = 0; start < len(tokens); start++ {
-		if tokens[start].Type != hclsyntax.TokenNewline {
-			break
+	// When we're only sorting the arguments, we format the file only if
+	// they weren't already in order, to avoid making any formatting changes
+	// to a file that is already clean.
+	formatted := result.Stats.Changed() || cleanOptions.Format
+	if sortAttrs {
+		if sorted := sortAttributes(newSrc, fn); !bytes.Equal(sorted, newSrc) {
+			newSrc = clean.Format(sorted)
+			formatted = true
 		}
 	}
-	for end = len(tokens); end > 0; end-- {
-		if tokens[end-1].Type != hclsyntax.TokenNewline {
-			break
-		}
+	if !formatted {
+		return src, result, nil
+	}
+	if indent != defaultIndent {
+		newSrc = reindent(newSrc, fn, indent)
+	}
+	if usesCRLF(src) {
+		newSrc = toCRLF(newSrc)
 	}
-	return tokens[start:end]
+	if hasBOM {
+		newSrc = append(append([]byte(nil), utf8BOM...), newSrc...)
+	}
+	return newSrc, result, nil
 }
+
+// utf8BOM is the byte order mark that some editors write at the start of
+// UTF-8 files.
+var utf8BOM = []byte{0xEF, 0xBB, 0xBF}
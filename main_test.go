@@ -0,0 +1,1580 @@
+package main
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/hashicorp/hcl/v2/hclwrite"
+
+	"github.com/apparentlymart/terraform-clean-syntax/clean"
+)
+
+// runMainEnv is the environment variable that makes the test binary run
+// the program itself instead of the tests, as arranged by TestMain.
+const runMainEnv = "TERRAFORM_CLEAN_SYNTAX_TEST_RUN_MAIN"
+
+// TestMain allows the tests to run the program as a subprocess of the test
+// binary, so that each run starts with fresh option variables and can exit
+// with its own status code.
+func TestMain(m *testing.M) {
+	if os.Getenv(runMainEnv) != "" {
+		main()
+		return
+	}
+	os.Exit(m.Run())
+}
+
+// runResult is the result of running the program with runMain.
+type runResult struct {
+	stdout string
+	stderr string
+	status int
+}
+
+// runMain runs the program with the given arguments in the given working
+// directory, with the given content on stdin. It fails the test if the
+// program doesn't finish within a generous time limit, which would suggest
+// that it's stuck.
+func runMain(t *testing.T, dir string, stdin string, args ...string) runResult {
+	t.Helper()
+	exe, err := os.Executable()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+	defer cancel()
+	cmd := exec.CommandContext(ctx, exe, args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), runMainEnv+"=1")
+	cmd.Stdin = strings.NewReader(stdin)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	err = cmd.Run()
+	if ctx.Err() != nil {
+		t.Fatalf("program didn't finish\nstderr:\n%s", stderr.String())
+	}
+
+	ret := runResult{
+		stdout: stdout.String(),
+		stderr: stderr.String(),
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		ret.status = exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatal(err)
+	}
+	return ret
+}
+
+// tempDir creates a temporary directory containing the given files, keyed
+// by their paths relative to it, and returns its path along with a function
+// to remove it.
+func tempDir(t testing.TB, files map[string]string) (string, func()) {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "terraform-clean-syntax")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		fn := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(fn), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(fn, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir, func() { os.RemoveAll(dir) }
+}
+
+// readFile returns the content of the file with the given name, failing the
+// test if it can't be read.
+func readFile(t *testing.T, fn string) string {
+	t.Helper()
+	src, err := ioutil.ReadFile(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(src)
+}
+
+func TestUsageErrors(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = b\n",
+	})
+	defer cleanup()
+
+	tests := [][]string{
+		{},
+		{"--no-such-option", "."},
+		{"--parallel=lots", "."},
+		{"--help"},
+	}
+	for _, args := range tests {
+		result := runMain(t, dir, "", args...)
+		if result.status != exitUsage {
+			t.Errorf("%q: wrong exit status %d; want %d\nstderr:\n%s", args, result.status, exitUsage, result.stderr)
+		}
+		if !strings.Contains(result.stderr, "Usage: terraform-clean-syntax") {
+			t.Errorf("%q: missing usage message\nstderr:\n%s", args, result.stderr)
+		}
+	}
+}
+
+// BenchmarkProcessCleanFiles measures processing a directory of files that
+// were cleaned before, which is the common case when running in CI. The
+// "format" case reformats each file, and so shows the cost of serializing
+// files that the other case avoids.
+func BenchmarkProcessCleanFiles(b *testing.B) {
+	files := make(map[string]string)
+	for i := 0; i < 100; i++ {
+		files[fmt.Sprintf("mod%d/file%d.tf", i%10, i)] = `variable "azs" {
+  type = list(string)
+}
+
+resource "aws_instance" "example" {
+  count             = length(var.azs)
+  availability_zone = var.azs[count.index]
+  tags = {
+    Name = "example-${count.index}"
+  }
+}
+`
+	}
+	dir, cleanup := tempDir(b, files)
+	defer cleanup()
+
+	// processArgs reports its results using the option and result
+	// variables, so we must reset them afterwards for any later tests.
+	// The defaults of the options are set up by main, so we also need to
+	// set those that the walk depends on.
+	defer func(opts clean.Options, check, quiet, r bool, depth, width, n int) {
+		cleanOptions, checkMode, quietMode, recursive, maxDepth, indent, parallel = opts, check, quiet, r, depth, width, n
+		filesProcessed, filesChanged = 0, 0
+		uncleanFiles = nil
+	}(cleanOptions, checkMode, quietMode, recursive, maxDepth, indent, parallel)
+	checkMode, quietMode, recursive, maxDepth, indent, parallel = true, true, true, -1, 2, 1
+
+	for _, format := range []bool{false, true} {
+		name := "default"
+		if format {
+			name = "format"
+		}
+		b.Run(name, func(b *testing.B) {
+			cleanOptions.Format = format
+			for i := 0; i < b.N; i++ {
+				processArgs(context.Background(), []string{dir})
+			}
+			if filesProcessed == 0 || len(uncleanFiles) > 0 {
+				b.Fatalf("processed %d files, of which %d need cleaning", filesProcessed, len(uncleanFiles))
+			}
+		})
+	}
+}
+
+func TestVerboseChangeLines(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": `a = "x"
+
+resource "x" "y" {
+  b = "${var.b}"
+  c = "${var.c}"
+}
+
+variable "v" {
+  type = "string"
+}
+`,
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--write", "--verbose", "main.tf")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	for _, want := range []string{
+		"  main.tf:4: unwrapped 1 interpolations\n",
+		"  main.tf:5: unwrapped 1 interpolations\n",
+		"  main.tf:9: converted 1 type constraints\n",
+	} {
+		if !strings.Contains(result.stderr, want) {
+			t.Errorf("missing %q\nstderr:\n%s", want, result.stderr)
+		}
+	}
+}
+
+func TestFailOnChange(t *testing.T) {
+	t.Run("clean", func(t *testing.T) {
+		dir, cleanup := tempDir(t, map[string]string{
+			"main.tf": "a = b\n",
+		})
+		defer cleanup()
+
+		result := runMain(t, dir, "", "--write", "--fail-on-change", ".")
+		if result.status != exitSuccess {
+			t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitSuccess, result.stderr)
+		}
+	})
+	t.Run("unclean", func(t *testing.T) {
+		dir, cleanup := tempDir(t, map[string]string{
+			"main.tf":  "a = \"${b}\"\n",
+			"other.tf": "c = d\n",
+		})
+		defer cleanup()
+
+		result := runMain(t, dir, "", "--write", "--fail-on-change", ".")
+		if result.status != exitUnclean {
+			t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitUnclean, result.stderr)
+		}
+		// The files are still written, unlike with --check.
+		if got, want := readFile(t, filepath.Join(dir, "main.tf")), "a = b\n"; got != want {
+			t.Errorf("wrong content\ngot:\n%s\nwant:\n%s", got, want)
+		}
+	})
+}
+
+func TestStdout(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":  "a = \"${b}\"\n",
+		"clean.tf": "a = b\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--stdout", "main.tf"}, "a = b\n"},
+		{[]string{"--stdout", "clean.tf"}, "a = b\n"},
+		// A single file is previewed in the same way by default.
+		{[]string{"main.tf"}, "a = b\n"},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("%q: wrong output\ngot:\n%s\nwant:\n%s", test.args, result.stdout, test.want)
+		}
+	}
+
+	// The file itself must not be modified.
+	if got, want := readFile(t, filepath.Join(dir, "main.tf")), "a = \"${b}\"\n"; got != want {
+		t.Errorf("file was modified\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	result := runMain(t, dir, "", "--stdout", ".")
+	if result.status != exitUsage {
+		t.Errorf("directory: wrong exit status %d; want %d\nstderr:\n%s", result.status, exitUsage, result.stderr)
+	}
+}
+
+func TestIncludeExclude(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":                   "a = \"${b}\"\n",
+		"modules/a/main.tf":         "a = \"${b}\"\n",
+		"modules/a/other.tf":        "a = \"${b}\"\n",
+		"modules/vendor/main.tf":    "a = \"${b}\"\n",
+		"modules/vendor/readme.txt": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		name string
+		args []string
+		want string
+	}{
+		{
+			// Files must still have one of the usual suffixes.
+			"include only",
+			[]string{"--include=modules/**"},
+			"modules/a/main.tf\nmodules/a/other.tf\nmodules/vendor/main.tf\n",
+		},
+		{
+			"exclude only",
+			[]string{"--exclude=modules/vendor"},
+			"main.tf\nmodules/a/main.tf\nmodules/a/other.tf\n",
+		},
+		{
+			"include and exclude",
+			[]string{"--include=modules/**/*.tf", "--include=main.tf", "--exclude=modules/vendor", "--exclude=**/other.tf"},
+			"main.tf\nmodules/a/main.tf\n",
+		},
+	}
+	for _, test := range tests {
+		test := test
+		t.Run(test.name, func(t *testing.T) {
+			args := append([]string{"--list"}, test.args...)
+			result := runMain(t, dir, "", append(args, ".")...)
+			if result.status != exitSuccess {
+				t.Errorf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+			}
+			if result.stdout != test.want {
+				t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", result.stdout, test.want)
+			}
+		})
+	}
+}
+
+func TestReportUnchanged(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf": "a = b\n",
+		"dirty.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"--check", "."}, false},
+		{[]string{"--check", "--report-unchanged", "."}, true},
+		// Like other informational messages, it's suppressed by --quiet.
+		{[]string{"--check", "--report-unchanged", "--quiet", "."}, false},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitUnclean {
+			t.Errorf("%q: wrong exit status %d; want %d\nstderr:\n%s", test.args, result.status, exitUnclean, result.stderr)
+		}
+		if got := strings.Contains(result.stderr, "No changes needed: clean.tf"); got != test.want {
+			t.Errorf("%q: wrong report of the unchanged file, or lack of one\nstderr:\n%s", test.args, result.stderr)
+		}
+		if strings.Contains(result.stderr, "No changes needed: dirty.tf") {
+			t.Errorf("%q: file that needs cleaning was reported as unchanged\nstderr:\n%s", test.args, result.stderr)
+		}
+	}
+}
+
+func TestAdvise(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf": "a = b\n",
+		"main.tf": `a = "${var.a}${var.b}"
+b = lookup(var.m, "k", "d")
+
+variable "v" {
+  type = "custom"
+}
+`,
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--advise", "--check", ".")
+	if result.status != exitSuccess {
+		t.Errorf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	want := `Left unchanged: main.tf
+  main.tf:1: template concatenating the interpolations of var.a and var.b, with no literal text between them, was left unchanged because it can't be unwrapped into a single expression
+  main.tf:2: call to lookup with a default value was left unchanged because it has no equivalent index syntax
+  main.tf:5: quoted type constraint "custom" was left unchanged because it isn't one of the legacy type names
+`
+	if !strings.Contains(result.stderr, want) {
+		t.Errorf("missing advice\nstderr:\n%s\nwant:\n%s", result.stderr, want)
+	}
+	if strings.Contains(result.stderr, "Left unchanged: clean.tf") {
+		t.Errorf("advice logged for a file without any legacy syntax\nstderr:\n%s", result.stderr)
+	}
+
+	// Without the option, the advice isn't logged at all.
+	result = runMain(t, dir, "", "--check", ".")
+	if strings.Contains(result.stderr, "Left unchanged:") {
+		t.Errorf("advice logged without --advise\nstderr:\n%s", result.stderr)
+	}
+}
+
+func TestCRLF(t *testing.T) {
+	const clean = "a = b\r\n\r\nvariable \"v\" {\r\n  type = string\r\n}\r\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf": clean,
+		"dirty.tf": "a = \"${b}\"\r\n\r\nvariable \"v\" {\r\n  type = \"string\"\r\n}\r\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "-w", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got := readFile(t, filepath.Join(dir, "clean.tf")); got != clean {
+		t.Errorf("clean file was modified\ngot:  %q\nwant: %q", got, clean)
+	}
+	if got := readFile(t, filepath.Join(dir, "dirty.tf")); got != clean {
+		t.Errorf("wrong result for dirty file\ngot:  %q\nwant: %q", got, clean)
+	}
+}
+
+func TestBOM(t *testing.T) {
+	const bom = "\xEF\xBB\xBF"
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf": bom + "a = b\n",
+		"dirty.tf": bom + "a = \"${b}\"\n",
+	})
+	defer cleanup()
+	cleanFn := filepath.Join(dir, "clean.tf")
+	old := time.Now().Add(-time.Hour).Truncate(time.Second)
+	if err := os.Chtimes(cleanFn, old, old); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runMain(t, dir, "", "-w", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "dirty.tf")), bom+"a = b\n"; got != want {
+		t.Errorf("wrong result for dirty file\ngot:  %q\nwant: %q", got, want)
+	}
+
+	// The byte order mark alone doesn't make the file need cleaning, so it
+	// must not be rewritten at all.
+	if got, want := readFile(t, cleanFn), bom+"a = b\n"; got != want {
+		t.Errorf("clean file was modified\ngot:  %q\nwant: %q", got, want)
+	}
+	info, err := os.Stat(cleanFn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !info.ModTime().Equal(old) {
+		t.Errorf("clean file was rewritten")
+	}
+}
+
+func TestIncludeHidden(t *testing.T) {
+	tests := []struct {
+		args       []string
+		wantHidden bool
+	}{
+		{[]string{"-w", "."}, false},
+		{[]string{"-w", "--include-hidden", "."}, true},
+	}
+	for _, test := range tests {
+		dir, cleanup := tempDir(t, map[string]string{
+			"main.tf":         "a = \"${b}\"\n",
+			".hidden/main.tf": "a = \"${b}\"\n",
+		})
+		defer cleanup()
+
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if got := readFile(t, filepath.Join(dir, "main.tf")); got != "a = b\n" {
+			t.Errorf("%q: main.tf wasn't cleaned", test.args)
+		}
+		got := readFile(t, filepath.Join(dir, ".hidden", "main.tf")) == "a = b\n"
+		if got != test.wantHidden {
+			t.Errorf("%q: .hidden/main.tf cleaned is %t; want %t", test.args, got, test.wantHidden)
+		}
+	}
+}
+
+func TestTfvars(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"terraform.tfvars":    "a = \"${b}\"\n",
+		"prod.auto.tfvars":    "a = \"${b}\"\n",
+		"terraform.tfvars.md": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "-w", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	for fn, want := range map[string]string{
+		"terraform.tfvars":    "a = b\n",
+		"prod.auto.tfvars":    "a = b\n",
+		"terraform.tfvars.md": "a = \"${b}\"\n",
+	} {
+		if got := readFile(t, filepath.Join(dir, fn)); got != want {
+			t.Errorf("wrong result for %s\ngot:\n%s\nwant:\n%s", fn, got, want)
+		}
+	}
+}
+
+func TestNotRecursive(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":     "a = \"${b}\"\n",
+		"sub/main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "-w", "--recursive=false", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "main.tf")), "a = b\n"; got != want {
+		t.Errorf("main.tf wasn't cleaned\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "sub", "main.tf")), "a = \"${b}\"\n"; got != want {
+		t.Errorf("sub/main.tf was modified\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	const dirty, clean = "a = \"${b}\"\n", "a = b\n"
+	tests := []struct {
+		depth int
+		want  map[string]string
+	}{
+		{0, map[string]string{
+			"main.tf":         clean,
+			"one/main.tf":     dirty,
+			"one/two/main.tf": dirty,
+		}},
+		{1, map[string]string{
+			"main.tf":         clean,
+			"one/main.tf":     clean,
+			"one/two/main.tf": dirty,
+		}},
+	}
+	for _, test := range tests {
+		dir, cleanup := tempDir(t, map[string]string{
+			"main.tf":         dirty,
+			"one/main.tf":     dirty,
+			"one/two/main.tf": dirty,
+		})
+		defer cleanup()
+
+		result := runMain(t, dir, "", "-w", fmt.Sprintf("--max-depth=%d", test.depth), ".")
+		if result.status != exitSuccess {
+			t.Errorf("depth %d: wrong exit status %d\nstderr:\n%s", test.depth, result.status, result.stderr)
+		}
+		for fn, want := range test.want {
+			if got := readFile(t, filepath.Join(dir, fn)); got != want {
+				t.Errorf("depth %d: wrong result for %s\ngot:\n%s\nwant:\n%s", test.depth, fn, got, want)
+			}
+		}
+	}
+}
+
+func TestForce(t *testing.T) {
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"-w", "--force", "sub/x.hcl"}, "a = b\n"},
+		// --force applies only to files given as arguments, and not to
+		// those found by visiting a directory.
+		{[]string{"-w", "--force", "sub"}, "a = \"${b}\"\n"},
+	}
+	for _, test := range tests {
+		dir, cleanup := tempDir(t, map[string]string{
+			"sub/x.hcl": "a = \"${b}\"\n",
+		})
+		defer cleanup()
+
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if got := readFile(t, filepath.Join(dir, "sub", "x.hcl")); got != test.want {
+			t.Errorf("%q: wrong result\ngot:\n%s\nwant:\n%s", test.args, got, test.want)
+		}
+	}
+}
+
+func TestGlobArguments(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":             "a = \"${b}\"\n",
+		"modules/a/main.tf":   "a = \"${b}\"\n",
+		"modules/a/b/main.tf": "a = \"${b}\"\n",
+		"modules/a/notes.txt": "a = \"${b}\"\n",
+		"weird[1].tf":         "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{"modules/**/*.tf", "modules/a/b/main.tf\nmodules/a/main.tf\n"},
+		{"main.tf", "main.tf\n"},
+		// A file that exists is taken literally even if its name looks
+		// like a pattern.
+		{"weird[1].tf", "weird[1].tf\n"},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", "--list", test.arg)
+		if result.status != exitSuccess {
+			t.Errorf("%s: wrong exit status %d\nstderr:\n%s", test.arg, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("%s: wrong output\ngot:\n%s\nwant:\n%s", test.arg, result.stdout, test.want)
+		}
+	}
+
+	result := runMain(t, dir, "", "--list", "nowhere/**/*.tf")
+	if result.status != exitError {
+		t.Errorf("no matches: wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	if want := `No files or directories match "nowhere/**/*.tf"`; !strings.Contains(result.stderr, want) {
+		t.Errorf("no matches: missing error message\nstderr:\n%s", result.stderr)
+	}
+}
+
+func TestFilesFrom(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a.tf":     "a = \"${b}\"\n",
+		"b.tf":     "a = \"${b}\"\n",
+		"sub/c.tf": "a = \"${b}\"\n",
+		// Empty lines and Windows line endings are allowed in the list.
+		"list.txt": "a.tf\r\n\r\nsub/c.tf\r\n",
+	})
+	defer cleanup()
+	const want = "a.tf\nsub/c.tf\n"
+
+	result := runMain(t, dir, "", "--list", "--files-from=list.txt")
+	if result.status != exitSuccess {
+		t.Errorf("list file: wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if result.stdout != want {
+		t.Errorf("list file: wrong output\ngot:\n%s\nwant:\n%s", result.stdout, want)
+	}
+
+	result = runMain(t, dir, "a.tf\nsub/c.tf\n", "--list", "--files-from=-")
+	if result.status != exitSuccess {
+		t.Errorf("stdin: wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if result.stdout != want {
+		t.Errorf("stdin: wrong output\ngot:\n%s\nwant:\n%s", result.stdout, want)
+	}
+}
+
+func TestIndent(t *testing.T) {
+	const clean = "resource \"x\" \"y\" {\n  a = b\n}\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf": clean,
+		"dirty.tf": "resource \"x\" \"y\" {\n  a = \"${b}\"\n  c {\n    d = 1\n  }\n}\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "-w", "--indent=4", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	want := "resource \"x\" \"y\" {\n    a = b\n    c {\n        d = 1\n    }\n}\n"
+	if got := readFile(t, filepath.Join(dir, "dirty.tf")); got != want {
+		t.Errorf("wrong result for dirty file\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	// Only the files that we modify are formatted.
+	if got := readFile(t, filepath.Join(dir, "clean.tf")); got != clean {
+		t.Errorf("clean file was reformatted\ngot:\n%s\nwant:\n%s", got, clean)
+	}
+}
+
+func TestDiffContext(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = 1\nb = 2\nc = \"${x}\"\nd = 4\ne = 5\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		context int
+		want    string
+	}{
+		{0, `--- main.tf
++++ main.tf
+@@ -3 +3 @@
+-c = "${x}"
++c = x
+`},
+		{1, `--- main.tf
++++ main.tf
+@@ -2,3 +2,3 @@
+ b = 2
+-c = "${x}"
++c = x
+ d = 4
+`},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", "--diff", fmt.Sprintf("--diff-context=%d", test.context), "main.tf")
+		if result.status != exitSuccess {
+			t.Errorf("context %d: wrong exit status %d\nstderr:\n%s", test.context, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("context %d: wrong diff\ngot:\n%s\nwant:\n%s", test.context, result.stdout, test.want)
+		}
+	}
+}
+
+func TestSummaryJSON(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"clean.tf":   "a = b\n",
+		"dirty.tf":   "a = \"${b}\"\n\nvariable \"v\" {\n  type = \"string\"\n}\n",
+		"invalid.tf": "a = \n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "-w", "--summary-json=summary.json", ".")
+	if result.status != exitError {
+		t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	src := readFile(t, filepath.Join(dir, "summary.json"))
+
+	// We decode into our own type, rather than jsonSummary, so that the
+	// test also checks the field names, and reject any that we don't
+	// expect.
+	var got struct {
+		FilesProcessed   int            `json:"files_processed"`
+		FilesWithChanges int            `json:"files_with_changes"`
+		FilesWritten     int            `json:"files_written"`
+		FilesInvalid     int            `json:"files_invalid"`
+		FilesUnsafe      int            `json:"files_unsafe"`
+		FilesSkipped     int            `json:"files_skipped"`
+		Errors           int            `json:"errors"`
+		Changes          map[string]int `json:"changes"`
+	}
+	dec := json.NewDecoder(strings.NewReader(src))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(&got); err != nil {
+		t.Fatalf("invalid summary: %s\n%s", err, src)
+	}
+	var keys map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(src), &keys); err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 8 {
+		t.Errorf("wrong number of fields %d; want 8\n%s", len(keys), src)
+	}
+
+	if got.FilesProcessed != 3 {
+		t.Errorf("wrong files_processed %d; want 3", got.FilesProcessed)
+	}
+	if got.FilesWithChanges != 1 {
+		t.Errorf("wrong files_with_changes %d; want 1", got.FilesWithChanges)
+	}
+	if got.FilesWritten != 1 {
+		t.Errorf("wrong files_written %d; want 1", got.FilesWritten)
+	}
+	if got.FilesInvalid != 1 {
+		t.Errorf("wrong files_invalid %d; want 1", got.FilesInvalid)
+	}
+	if got.FilesUnsafe != 0 {
+		t.Errorf("wrong files_unsafe %d; want 0", got.FilesUnsafe)
+	}
+	if got.FilesSkipped != 0 {
+		t.Errorf("wrong files_skipped %d; want 0", got.FilesSkipped)
+	}
+	if got.Errors != 1 {
+		t.Errorf("wrong errors %d; want 1", got.Errors)
+	}
+	wantChanges := map[string]int{
+		"interpolations":    1,
+		"type_constraints":  1,
+		"provider_refs":     0,
+		"index_calls":       0,
+		"splats":            0,
+		"collection_calls":  0,
+		"custom_transforms": 0,
+	}
+	if !reflect.DeepEqual(got.Changes, wantChanges) {
+		t.Errorf("wrong changes %v; want %v", got.Changes, wantChanges)
+	}
+}
+
+func TestConfigFile(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		".terraform-clean-syntax.yml": `exclude:
+  - skip/**
+  - other.tf
+list: true
+`,
+		"main.tf":   "a = \"${b}\"\n",
+		"other.tf":  "a = \"${b}\"\n",
+		"skip/a.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"."}, "main.tf\n"},
+		// Options given on the command line replace those in the file,
+		// rather than adding to them, even if they can be repeated.
+		{[]string{"--exclude=main.tf", "."}, "other.tf\nskip/a.tf\n"},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("%q: wrong output\ngot:\n%s\nwant:\n%s", test.args, result.stdout, test.want)
+		}
+	}
+}
+
+func TestConfigFileInvalid(t *testing.T) {
+	tests := map[string]string{
+		"unknown option": "no-such-option: true\n",
+		"invalid value":  "parallel: lots\n",
+		"invalid yaml":   "exclude: [\n",
+	}
+	for name, config := range tests {
+		t.Run(name, func(t *testing.T) {
+			dir, cleanup := tempDir(t, map[string]string{
+				".terraform-clean-syntax.yml": config,
+				"main.tf":                     "a = b\n",
+			})
+			defer cleanup()
+
+			result := runMain(t, dir, "", ".")
+			if result.status != exitUsage {
+				t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitUsage, result.stderr)
+			}
+			if !strings.Contains(result.stderr, "Failed to load .terraform-clean-syntax.yml") {
+				t.Errorf("missing error message\nstderr:\n%s", result.stderr)
+			}
+		})
+	}
+}
+
+func TestMaxFileSize(t *testing.T) {
+	const src = "a = b\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": src,
+	})
+	defer cleanup()
+	const skipped = `Skipping "main.tf": larger than the maximum file size`
+
+	tests := []struct {
+		name  string
+		limit int
+		skip  bool
+	}{
+		{"just under", len(src) + 1, false},
+		{"at the limit", len(src), false},
+		{"just over", len(src) - 1, true},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			// We can't tell whether a skipped file is clean, so --check
+			// must fail, and the reason must be logged even in quiet mode.
+			result := runMain(t, dir, "", "--check", "--quiet", fmt.Sprintf("--max-file-size=%d", test.limit), ".")
+			wantStatus := exitSuccess
+			if test.skip {
+				wantStatus = exitUnclean
+			}
+			if result.status != wantStatus {
+				t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, wantStatus, result.stderr)
+			}
+			if got := strings.Contains(result.stderr, skipped); got != test.skip {
+				t.Errorf("wrong skip message, or lack of one\nstderr:\n%s", result.stderr)
+			}
+		})
+	}
+}
+
+func TestPerFileTimeout(t *testing.T) {
+	defer func(opts clean.Options, timeout time.Duration, quiet bool, skipped int) {
+		cleanOptions, perFileTimeout, quietMode, filesSkipped = opts, timeout, quiet, skipped
+	}(cleanOptions, perFileTimeout, quietMode, filesSkipped)
+	defer log.SetOutput(os.Stderr)
+
+	// None of the built-in rules is reliably slow, so we'll use a custom
+	// transform that takes far longer than the timeout.
+	release := make(chan struct{})
+	defer close(release)
+	cleanOptions = clean.Options{
+		Transforms: []clean.Transform{
+			func(name string, tokens hclwrite.Tokens) (hclwrite.Tokens, bool) {
+				<-release
+				return tokens, false
+			},
+		},
+	}
+	perFileTimeout = 10 * time.Millisecond
+	quietMode = true
+	filesSkipped = 0
+	var logged bytes.Buffer
+	log.SetOutput(&logged)
+
+	// We can't tell whether a skipped file is clean, so it must be counted
+	// for --check, and the reason must be logged even in quiet mode.
+	if _, _, _, ok := cleanSource([]byte("a = \"${b}\"\n"), "slow.tf"); ok {
+		t.Fatalf("cleanSource succeeded; want timeout")
+	}
+	if filesSkipped != 1 {
+		t.Errorf("wrong number of skipped files %d; want 1", filesSkipped)
+	}
+	const want = `Skipping "slow.tf": took longer than the --per-file-timeout of 10ms`
+	if !strings.Contains(logged.String(), want) {
+		t.Errorf("missing skip message\nlog:\n%s", logged.String())
+	}
+}
+
+func TestConflictingOptions(t *testing.T) {
+	const src = "a = \"${b}\"\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": src,
+	})
+	defer cleanup()
+
+	tests := []struct {
+		args []string
+		want string
+	}{
+		{[]string{"--write", "--stdout", "main.tf"}, "Only one of the --write, --stdout, --diff, --output-patch, and --output-dir options can be used"},
+		{[]string{"--diff", "--output-dir=out", "."}, "Only one of the --write, --stdout, --diff, --output-patch, and --output-dir options can be used"},
+		{[]string{"--write", "--check", "."}, "The --write option cannot be used with --check, --list, --preview, or --json"},
+		{[]string{"--in-place", "--json", "."}, "The --write option cannot be used with --check, --list, --preview, or --json"},
+		{[]string{"--list", "--preview", "."}, "Only one of the --list, --preview, and --json options can be used"},
+		{[]string{"--diff", "--list", "."}, "The --stdout and --diff options cannot be used with --list, --preview, or --json"},
+		{[]string{"--stdout", "."}, "The --stdout option can only be used with a single file"},
+		{[]string{"--tar", "main.tf"}, "The --tar option can only be used when reading from stdin"},
+		{[]string{"--archive", "--write", "main.tf"}, "The --archive option can only be used with files"},
+		{[]string{"--fail-on-change", "."}, "The --fail-on-change option can only be used with --write"},
+		{[]string{"--preserve-mtime", "--check", "."}, "The --preserve-mtime option can only be used with --write"},
+		{[]string{"--write", "-"}, "options cannot be used when reading from stdin"},
+		{[]string{"--quiet", "--verbose", "."}, "The --quiet and --verbose options cannot be used together"},
+		{[]string{"--only-types", "--only-interps", "."}, "The --only-types and --only-interps options cannot be used together"},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", test.args...)
+		if result.status != exitUsage {
+			t.Errorf("%q: wrong exit status %d; want %d\nstderr:\n%s", test.args, result.status, exitUsage, result.stderr)
+		}
+		if !strings.Contains(result.stderr, test.want) {
+			t.Errorf("%q: missing error message %q\nstderr:\n%s", test.args, test.want, result.stderr)
+		}
+	}
+
+	// None of those runs should have done anything to the file.
+	if got := readFile(t, filepath.Join(dir, "main.tf")); got != src {
+		t.Errorf("file was modified\ngot:\n%s\nwant:\n%s", got, src)
+	}
+}
+
+func TestMissingArgument(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--list", "main.tf", "missing")
+	if result.status != exitError {
+		t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	if !strings.Contains(result.stderr, `Failed to stat "missing"`) {
+		t.Errorf("missing error message\nstderr:\n%s", result.stderr)
+	}
+	// The other arguments are still processed.
+	if got, want := result.stdout, "main.tf\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestFileRemovedDuringWalk(t *testing.T) {
+	dir, cleanup := tempDir(t, nil)
+	defer cleanup()
+	fn := filepath.Join(dir, "removed.tf")
+
+	defer func(quiet bool) {
+		quietMode = quiet
+		hadErrors, errorCount = false, 0
+	}(quietMode)
+	quietMode = true
+
+	// We can't easily remove a file at just the right moment during a
+	// real walk, so we'll visit one that doesn't exist as if we'd found
+	// it by reading its directory, and also as if it were an argument.
+	queue := make(chan fileJob, 1)
+	w := newWalker(context.Background(), queue)
+	w.processItem(fn, nil, 1)
+	if hadErrors {
+		t.Errorf("file found during the walk was reported as an error")
+	}
+	w.processItem(fn, nil, 0)
+	if !hadErrors {
+		t.Errorf("file given as an argument wasn't reported as an error")
+	}
+	if len(queue) != 0 {
+		t.Errorf("missing file was queued for processing")
+	}
+}
+
+func TestOutputDir(t *testing.T) {
+	files := map[string]string{
+		"a/main.tf":     "a = \"${b}\"\n",
+		"a/sub/main.tf": "a = b\n",
+		"b/main.tf":     "a = \"${b}\"\n",
+	}
+	tests := []struct {
+		args []string
+		want map[string]string
+	}{
+		{
+			[]string{"a"},
+			map[string]string{
+				"main.tf":     "a = b\n",
+				"sub/main.tf": "a = b\n",
+			},
+		},
+		{
+			// Each of these has a main.tf, so the copies must be kept
+			// apart by the paths of the arguments.
+			[]string{"a", "b"},
+			map[string]string{
+				"a/main.tf":     "a = b\n",
+				"a/sub/main.tf": "a = b\n",
+				"b/main.tf":     "a = b\n",
+			},
+		},
+		{
+			[]string{"a/main.tf", "b/main.tf"},
+			map[string]string{
+				"a/main.tf": "a = b\n",
+				"b/main.tf": "a = b\n",
+			},
+		},
+	}
+	for _, test := range tests {
+		dir, cleanup := tempDir(t, files)
+		defer cleanup()
+
+		result := runMain(t, dir, "", append([]string{"--output-dir=out"}, test.args...)...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		got := make(map[string]string)
+		out := filepath.Join(dir, "out")
+		err := filepath.Walk(out, func(fn string, info os.FileInfo, err error) error {
+			if err != nil || info.IsDir() {
+				return err
+			}
+			rel, err := filepath.Rel(out, fn)
+			if err != nil {
+				return err
+			}
+			got[filepath.ToSlash(rel)] = readFile(t, fn)
+			return nil
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(got) != len(test.want) {
+			t.Errorf("%q: wrong files\ngot:  %q\nwant: %q", test.args, got, test.want)
+			continue
+		}
+		for name, want := range test.want {
+			if got[name] != want {
+				t.Errorf("%q: wrong content for %s\ngot:\n%s\nwant:\n%s", test.args, name, got[name], want)
+			}
+		}
+	}
+}
+
+func TestOutputDirOutsideWorkingDir(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a/main.tf": "a = \"${b}\"\n",
+		"b/main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, filepath.Join(dir, "a"), "", "--output-dir=out", ".", "../b")
+	if result.status != exitError {
+		t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	if !strings.Contains(result.stderr, `Failed to write the cleaned copy of "../b/main.tf": it is outside the current working directory`) {
+		t.Errorf("missing error message\nstderr:\n%s", result.stderr)
+	}
+	if got, want := readFile(t, filepath.Join(dir, "a", "out", "main.tf")), "a = b\n"; got != want {
+		t.Errorf("wrong content for out/main.tf\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestProgressValue(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	// The value may be given as a separate argument, as for any other
+	// option, rather than being taken as the name of a file to process.
+	result := runMain(t, dir, "", "--list", "--progress", "never", ".")
+	if result.status != exitSuccess {
+		t.Errorf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "main.tf\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	result = runMain(t, dir, "", "--list", "--progress=always", ".")
+	if result.status != exitSuccess {
+		t.Errorf("always: wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+}
+
+func TestSortAttrs(t *testing.T) {
+	const src = `resource "x" "y" {
+  # about zed
+  zed      = 1
+  provider = aws.x
+  alpha    = "b" # trailing
+  count    = 2
+  for_each = {}
+
+  nested {
+    b = 1
+    a = 2
+  }
+  other {}
+}
+`
+	// The meta-arguments come first, in their usual order, and comments
+	// stay with the arguments they describe. The blocks aren't reordered.
+	const want = `resource "x" "y" {
+  count    = 2
+  for_each = {}
+  provider = aws.x
+  alpha    = "b" # trailing
+  # about zed
+  zed = 1
+
+  nested {
+    a = 2
+    b = 1
+  }
+  other {}
+}
+`
+	result := runMain(t, "", src, "--sort-attrs", "-")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if result.stdout != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", result.stdout, want)
+	}
+}
+
+func TestTestFiles(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":                 "a = b\n",
+		"tests/main.tftest.hcl":   "run \"x\" {\n  variables {\n    a = \"${var.b}\"\n  }\n}\n",
+		"tests/other.hcl":         "a = \"${b}\"\n",
+		"tests/setup.tfvars.json": "{}\n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--list", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "tests/main.tftest.hcl\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	result = runMain(t, dir, "", "--list", "--ext=.hcl", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("--ext: wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "tests/main.tftest.hcl\ntests/other.hcl\n"; got != want {
+		t.Errorf("--ext: wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestUnsafeRewrite(t *testing.T) {
+	const src = "a = \"${b}\"\nc = a + (b)\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": src,
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--write", ".")
+	if result.status != exitError {
+		t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	if !strings.Contains(result.stderr, "Can't safely rewrite 1 files, which were not cleaned") {
+		t.Errorf("missing summary\nstderr:\n%s", result.stderr)
+	}
+	if strings.Contains(result.stderr, "Failed to parse") {
+		t.Errorf("file was reported as invalid\nstderr:\n%s", result.stderr)
+	}
+	if got := readFile(t, filepath.Join(dir, "main.tf")); got != src {
+		t.Errorf("file was modified\ngot:\n%s\nwant:\n%s", got, src)
+	}
+}
+
+func TestDiagnosticOutput(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"bad.tf": "a = \"${b}\"\nc = \n",
+	})
+	defer cleanup()
+
+	result := runMain(t, dir, "", "--list", "bad.tf")
+	if result.status != exitError {
+		t.Errorf("wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	// The message includes a snippet of the source code around the
+	// problem, as written by HCL's diagnostic writer.
+	want := `Error: Invalid expression
+
+  on bad.tf line 2:
+   2: c = 
+
+Expected the start of an expression, but found an invalid expression token.
+`
+	if !strings.Contains(result.stderr, want) {
+		t.Errorf("missing diagnostic\ngot:\n%s\nwant:\n%s", result.stderr, want)
+	}
+
+	// The JSON output has only a single line for each error instead.
+	result = runMain(t, dir, "", "--json", "bad.tf")
+	if result.status != exitError {
+		t.Errorf("JSON: wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	want = `"error": "[bad.tf:2] Invalid expression: Expected the start of an expression, but found an invalid expression token."`
+	if !strings.Contains(result.stdout, want) {
+		t.Errorf("JSON: missing error\ngot:\n%s\nwant:\n%s", result.stdout, want)
+	}
+}
+
+func TestRoot(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"modules/net/main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+	sub := filepath.Join(dir, "modules", "net")
+
+	tests := []struct {
+		wd   string
+		args []string
+	}{
+		{sub, []string{"--root=../..", "."}},
+		{dir, []string{"--root=.", sub}},
+		{sub, []string{"--root=" + dir, "main.tf"}},
+	}
+	for _, test := range tests {
+		result := runMain(t, test.wd, "", append([]string{"--list"}, test.args...)...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if got, want := result.stdout, "modules/net/main.tf\n"; got != want {
+			t.Errorf("%q: wrong output\ngot:\n%s\nwant:\n%s", test.args, got, want)
+		}
+	}
+
+	// The summary file is named relative to the original working
+	// directory, but the paths logged are relative to the root.
+	result := runMain(t, sub, "", "--write", "--verbose", "--root=../..", "--summary-json=summary.json", ".")
+	if result.status != exitSuccess {
+		t.Errorf("write: wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if !strings.Contains(result.stderr, "Made changes: modules/net/main.tf\n") {
+		t.Errorf("write: missing relative path\nstderr:\n%s", result.stderr)
+	}
+	if !strings.Contains(readFile(t, filepath.Join(sub, "summary.json")), `"files_written": 1`) {
+		t.Errorf("write: wrong summary file")
+	}
+}
+
+func TestArchive(t *testing.T) {
+	dir, cleanup := tempDir(t, nil)
+	defer cleanup()
+
+	var buf bytes.Buffer
+	zw := zip.NewWriter(&buf)
+	for _, entry := range []struct{ name, content string }{
+		{"main.tf", "a = \"${b}\"\n"},
+		{"variables.tf", "variable \"a\" {}\n"},
+		{"README.md", "a = \"${b}\"\n"},
+		{"modules/", ""},
+		{"modules/x/main.tf", "variable \"a\" {\n  type = \"string\"\n}\n"},
+	} {
+		w, err := zw.Create(entry.name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := w.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := zw.Close(); err != nil {
+		t.Fatal(err)
+	}
+	fn := filepath.Join(dir, "module.zip")
+	if err := ioutil.WriteFile(fn, buf.Bytes(), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runMain(t, dir, "", "--archive", "--list", "module.zip")
+	if result.status != exitSuccess {
+		t.Errorf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "module.zip/main.tf\nmodule.zip/modules/x/main.tf\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+
+	result = runMain(t, dir, "", "--archive", "--check", "module.zip")
+	if result.status != exitUnclean {
+		t.Errorf("check: wrong exit status %d; want %d\nstderr:\n%s", result.status, exitUnclean, result.stderr)
+	}
+
+	if got := readFile(t, fn); got != buf.String() {
+		t.Errorf("archive was modified")
+	}
+}
+
+func TestTar(t *testing.T) {
+	dir, cleanup := tempDir(t, nil)
+	defer cleanup()
+
+	modTime := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	entries := []struct {
+		hdr         tar.Header
+		content     string
+		wantContent string
+	}{
+		{
+			tar.Header{Name: "modules/", Typeflag: tar.TypeDir, Mode: 0755},
+			"",
+			"",
+		},
+		{
+			tar.Header{Name: "modules/main.tf", Typeflag: tar.TypeReg, Mode: 0640},
+			"a = \"${b}\"\n",
+			"a = b\n",
+		},
+		{
+			tar.Header{Name: "modules/variables.tf", Typeflag: tar.TypeReg, Mode: 0644},
+			"variable \"a\" {}\n",
+			"variable \"a\" {}\n",
+		},
+		{
+			tar.Header{Name: "README.md", Typeflag: tar.TypeReg, Mode: 0600},
+			"a = \"${b}\"\n",
+			"a = \"${b}\"\n",
+		},
+	}
+
+	var buf bytes.Buffer
+	tw := tar.NewWriter(&buf)
+	for _, entry := range entries {
+		hdr := entry.hdr
+		hdr.Size = int64(len(entry.content))
+		hdr.ModTime = modTime
+		hdr.Uname = "terraform"
+		if err := tw.WriteHeader(&hdr); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := tw.Write([]byte(entry.content)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runMain(t, dir, buf.String(), "--tar", "-")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+
+	tr := tar.NewReader(strings.NewReader(result.stdout))
+	for _, entry := range entries {
+		hdr, err := tr.Next()
+		if err != nil {
+			t.Fatalf("failed to read %q from the output: %s", entry.hdr.Name, err)
+		}
+		if hdr.Name != entry.hdr.Name || hdr.Typeflag != entry.hdr.Typeflag || hdr.Mode != entry.hdr.Mode || !hdr.ModTime.Equal(modTime) || hdr.Uname != "terraform" {
+			t.Errorf("wrong header for %q: %#v", entry.hdr.Name, hdr)
+		}
+		content, err := ioutil.ReadAll(tr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(content) != entry.wantContent {
+			t.Errorf("wrong content for %q\ngot:\n%s\nwant:\n%s", entry.hdr.Name, content, entry.wantContent)
+		}
+	}
+	if _, err := tr.Next(); err != io.EOF {
+		t.Errorf("output has extra entries, or is invalid: %v", err)
+	}
+}
+
+func TestColor(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	tests := []struct {
+		args  []string
+		color bool
+	}{
+		{[]string{"--color=always"}, true},
+		{[]string{"--color=never"}, false},
+		// The value may be given as a separate argument, as for any other
+		// option, rather than being taken as the name of a file to process.
+		{[]string{"--color", "never"}, false},
+		{[]string{"--color=always", "--no-color"}, false},
+	}
+	for _, test := range tests {
+		args := append(append([]string{"--diff"}, test.args...), ".")
+		result := runMain(t, dir, "", args...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if !strings.Contains(result.stdout, "+a = b") {
+			t.Errorf("%q: missing diff\nstdout:\n%s", test.args, result.stdout)
+		}
+		if got := strings.Contains(result.stdout, "\x1b["); got != test.color {
+			t.Errorf("%q: wrong use of color %t; want %t\nstdout:\n%q", test.args, got, test.color, result.stdout)
+		}
+	}
+}
+
+func TestPreserveMtime(t *testing.T) {
+	old := time.Date(2020, 1, 2, 3, 4, 5, 0, time.UTC)
+	tests := []struct {
+		args     []string
+		preserve bool
+	}{
+		{[]string{"--write"}, false},
+		{[]string{"--write", "--preserve-mtime"}, true},
+	}
+	for _, test := range tests {
+		dir, cleanup := tempDir(t, map[string]string{
+			"main.tf": "a = \"${b}\"\n",
+		})
+		defer cleanup()
+		fn := filepath.Join(dir, "main.tf")
+		if err := os.Chtimes(fn, old, old); err != nil {
+			t.Fatal(err)
+		}
+
+		result := runMain(t, dir, "", append(test.args, "main.tf")...)
+		if result.status != exitSuccess {
+			t.Errorf("%q: wrong exit status %d\nstderr:\n%s", test.args, result.status, result.stderr)
+		}
+		if got, want := readFile(t, fn), "a = b\n"; got != want {
+			t.Errorf("%q: file wasn't cleaned\ngot:\n%s\nwant:\n%s", test.args, got, want)
+		}
+		info, err := os.Stat(fn)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := info.ModTime().Equal(old); got != test.preserve {
+			t.Errorf("%q: wrong modification time %s", test.args, info.ModTime())
+		}
+	}
+}
+
+func TestIgnoreFiles(t *testing.T) {
+	const unclean = "a = \"${b}\"\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		".git/HEAD": "ref: refs/heads/main\n",
+		".terraformcleanignore": `# generated by our tools
+generated/
+*.gen.tf
+!keep.gen.tf
+`,
+		"main.tf":                           unclean,
+		"x.gen.tf":                          unclean,
+		"keep.gen.tf":                       unclean,
+		"generated/a.tf":                    unclean,
+		"modules/net/.terraformcleanignore": "!generated/\nlocal.tf\n",
+		"modules/net/main.tf":               unclean,
+		"modules/net/local.tf":              unclean,
+		"modules/net/y.gen.tf":              unclean,
+		"modules/net/generated/b.tf":        unclean,
+	})
+	defer cleanup()
+	net := filepath.Join(dir, "modules", "net")
+
+	tests := []struct {
+		wd   string
+		args []string
+		want string
+	}{
+		{dir, []string{"."}, "keep.gen.tf\nmain.tf\nmodules/net/generated/b.tf\nmodules/net/main.tf\n"},
+		// The ignore files in the directories above an argument apply to
+		// it too, up to the root of the repository.
+		{net, []string{"."}, "generated/b.tf\nmain.tf\n"},
+		{dir, []string{"modules/net"}, "modules/net/generated/b.tf\nmodules/net/main.tf\n"},
+		{net, []string{"main.tf", "y.gen.tf", "local.tf"}, "main.tf\n"},
+	}
+	for _, test := range tests {
+		result := runMain(t, test.wd, "", append([]string{"--list"}, test.args...)...)
+		if result.status != exitSuccess {
+			t.Errorf("%s %q: wrong exit status %d\nstderr:\n%s", test.wd, test.args, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("%s %q: wrong output\ngot:\n%s\nwant:\n%s", test.wd, test.args, result.stdout, test.want)
+		}
+	}
+}
+
+func TestIgnoreFilesOutsideRepository(t *testing.T) {
+	const unclean = "a = \"${b}\"\n"
+	dir, cleanup := tempDir(t, map[string]string{
+		".terraformcleanignore": "*.gen.tf\n",
+		"sub/main.tf":           unclean,
+		"sub/x.gen.tf":          unclean,
+	})
+	defer cleanup()
+
+	// Without a repository, we can't tell which of the directories above
+	// the argument belong to the project, so we don't look at any of them.
+	result := runMain(t, filepath.Join(dir, "sub"), "", "--list", ".")
+	if result.status != exitSuccess {
+		t.Errorf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "main.tf\nx.gen.tf\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+}
+
+func TestStatsByDir(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf":                  "a = b\n",
+		"modules/net/main.tf":      "a = \"${b}\"\nc = \"${d}\"\n",
+		"modules/net/variables.tf": "variable \"a\" {\n  type = \"string\"\n}\n",
+		"modules/net/outputs.tf":   "output \"a\" {\n  value = a\n}\n",
+		"modules/storage/main.tf":  "a = b\n",
+		"modules/x/main.tf":        "a = \"${b}\"\n",
+	})
+	defer cleanup()
+
+	// Each file counts only in the directory that directly contains it,
+	// and the directories are in order by path.
+	const want = `Changes by directory:
+  .: 0 of 1 files changed, with 0 changes
+  modules/net: 2 of 3 files changed, with 3 changes
+  modules/storage: 0 of 1 files changed, with 0 changes
+  modules/x: 1 of 1 files changed, with 1 changes
+`
+	tests := []struct {
+		args   []string
+		status int
+	}{
+		{[]string{"--check", "--parallel=4"}, exitUnclean},
+		{[]string{"--write"}, exitSuccess},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", append(append(test.args, "--stats-by-dir"), ".")...)
+		if result.status != test.status {
+			t.Errorf("%q: wrong exit status %d; want %d\nstderr:\n%s", test.args, result.status, test.status, result.stderr)
+		}
+		if !strings.Contains(result.stderr, want) {
+			t.Errorf("%q: wrong breakdown\ngot:\n%s\nwant:\n%s", test.args, result.stderr, want)
+		}
+	}
+}
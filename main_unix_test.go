@@ -0,0 +1,190 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+)
+
+func TestNonRegularFile(t *testing.T) {
+	dir, cleanup := tempDir(t, nil)
+	defer cleanup()
+	if err := syscall.Mkfifo(filepath.Join(dir, "pipe.tf"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// If we were to read the named pipe as if it were a regular file then
+	// we'd wait forever for something to write to it, so runMain would
+	// fail the test.
+	for _, arg := range []string{"pipe.tf", "."} {
+		result := runMain(t, dir, "", "--list", arg)
+		if result.status != exitSuccess {
+			t.Errorf("%s: wrong exit status %d\nstderr:\n%s", arg, result.status, result.stderr)
+		}
+		if !strings.Contains(result.stderr, `Skipping "pipe.tf": not a regular file or directory`) {
+			t.Errorf("%s: missing skip message\nstderr:\n%s", arg, result.stderr)
+		}
+	}
+}
+
+func TestSymlinkLoop(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"a/main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+	if err := os.Symlink("..", filepath.Join(dir, "a", "loop")); err != nil {
+		t.Fatal(err)
+	}
+
+	// runMain fails the test if the program doesn't terminate.
+	result := runMain(t, dir, "", "--list", "--follow-symlinks", ".")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := result.stdout, "a/main.tf\n"; got != want {
+		t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	if !strings.Contains(result.stderr, "already visited this directory") {
+		t.Errorf("missing message about the loop\nstderr:\n%s", result.stderr)
+	}
+}
+
+func TestPreserveOwner(t *testing.T) {
+	// Only root can give a file to another user, so we have no way to
+	// create a file we don't own otherwise.
+	if os.Geteuid() != 0 {
+		t.Skip("must run as root to change the owner of a file")
+	}
+	dir, cleanup := tempDir(t, map[string]string{
+		"main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+	fn := filepath.Join(dir, "main.tf")
+	const uid, gid = 1234, 5678
+	if err := os.Chown(fn, uid, gid); err != nil {
+		t.Fatal(err)
+	}
+
+	result := runMain(t, dir, "", "--write", "main.tf")
+	if result.status != exitSuccess {
+		t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+	}
+	if got, want := readFile(t, fn), "a = b\n"; got != want {
+		t.Fatalf("file wasn't cleaned\ngot:\n%s\nwant:\n%s", got, want)
+	}
+	info, err := os.Stat(fn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	st := info.Sys().(*syscall.Stat_t)
+	if st.Uid != uid || st.Gid != gid {
+		t.Errorf("wrong owner %d:%d; want %d:%d", st.Uid, st.Gid, uid, gid)
+	}
+}
+
+func TestSinceUntracked(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"changed.tf":     "a = \"${b}\"\n",
+		"new.tf":         "a = \"${b}\"\n",
+		"unchanged.tf":   "a = \"${b}\"\n",
+		"sub/changed.tf": "a = \"${b}\"\n",
+		"sub/café.tf":    "a = \"${b}\"\n",
+		// This stands in for git, printing the files that the real git
+		// would report for each command, and failing for any other.
+		"bin/git": `#!/bin/sh
+case "$*" in
+"diff --name-only -z --relative --diff-filter=d main --")
+	printf 'changed.tf\000sub/changed.tf\000sub/caf\303\251.tf\000'
+	;;
+"ls-files --others --exclude-standard -z")
+	printf 'new.tf\000'
+	;;
+*)
+	echo "unexpected git command: $*" >&2
+	exit 1
+	;;
+esac
+`,
+	})
+	defer cleanup()
+	if err := os.Chmod(filepath.Join(dir, "bin", "git"), 0755); err != nil {
+		t.Fatal(err)
+	}
+
+	// The program inherits our environment, so we'll arrange for it to
+	// find our git instead of the real one.
+	defer os.Setenv("PATH", os.Getenv("PATH"))
+	os.Setenv("PATH", filepath.Join(dir, "bin")+string(os.PathListSeparator)+os.Getenv("PATH"))
+
+	tests := []struct {
+		arg  string
+		want string
+	}{
+		{".", "changed.tf\nnew.tf\nsub/café.tf\nsub/changed.tf\n"},
+		{"sub", "sub/café.tf\nsub/changed.tf\n"},
+	}
+	for _, test := range tests {
+		result := runMain(t, dir, "", "--list", "--since=main", test.arg)
+		if result.status != exitSuccess {
+			t.Errorf("%s: wrong exit status %d\nstderr:\n%s", test.arg, result.status, result.stderr)
+		}
+		if result.stdout != test.want {
+			t.Errorf("%s: wrong output\ngot:\n%s\nwant:\n%s", test.arg, result.stdout, test.want)
+		}
+	}
+
+	result := runMain(t, dir, "", "--list", "--since=other", ".")
+	if result.status != exitError {
+		t.Errorf("unknown ref: wrong exit status %d; want %d\nstderr:\n%s", result.status, exitError, result.stderr)
+	}
+	if !strings.Contains(result.stderr, "unexpected git command: diff --name-only -z --relative --diff-filter=d other --") {
+		t.Errorf("unknown ref: missing error from git\nstderr:\n%s", result.stderr)
+	}
+}
+
+func TestSymlinks(t *testing.T) {
+	dir, cleanup := tempDir(t, map[string]string{
+		"real/main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanup()
+	external, cleanupExternal := tempDir(t, map[string]string{
+		"main.tf": "a = \"${b}\"\n",
+	})
+	defer cleanupExternal()
+	if err := os.Symlink(external, filepath.Join(dir, "ext")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Symlink("missing", filepath.Join(dir, "broken.tf")); err != nil {
+		t.Fatal(err)
+	}
+
+	t.Run("default", func(t *testing.T) {
+		result := runMain(t, dir, "", "--list", ".")
+		if result.status != exitSuccess {
+			t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+		}
+		if got, want := result.stdout, "real/main.tf\n"; got != want {
+			t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+		}
+		if !strings.Contains(result.stderr, `Skipping "ext": symbolic link, which is only followed with --follow-symlinks`) {
+			t.Errorf("missing skip message\nstderr:\n%s", result.stderr)
+		}
+	})
+	t.Run("follow", func(t *testing.T) {
+		result := runMain(t, dir, "", "--list", "--follow-symlinks", ".")
+		if result.status != exitSuccess {
+			t.Fatalf("wrong exit status %d\nstderr:\n%s", result.status, result.stderr)
+		}
+		if got, want := result.stdout, "ext/main.tf\nreal/main.tf\n"; got != want {
+			t.Errorf("wrong output\ngot:\n%s\nwant:\n%s", got, want)
+		}
+		if !strings.Contains(result.stderr, `Skipping "broken.tf": symbolic link to something that doesn't exist`) {
+			t.Errorf("missing skip message for broken link\nstderr:\n%s", result.stderr)
+		}
+	})
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"errors"
+)
+
+// outputMode describes what we do with the cleaned source code of each file
+// that needs changes. It is decided once by chooseOutputMode, based on the
+// options given on the command line.
+type outputMode int
+
+const (
+	// outputNone means that we don't output the cleaned source code at all,
+	// and only report which files need changes. This is the mode for
+	// --check, --list, --preview, and --json when used alone.
+	outputNone outputMode = iota
+
+	// outputInPlace means that we rewrite each file in place, as selected
+	// by --write.
+	outputInPlace
+
+	// outputStdout means that we print the cleaned source code of a single
+	// file to stdout, as selected by --stdout or when previewing the
+	// changes to a single file.
+	outputStdout
+
+	// outputDiff means that we print a diff of the changes to each file to
+	// stdout, as selected by --diff or when previewing the changes to
+	// several files.
+	outputDiff
+
+	// outputPatchFile means that we write a diff of the changes to all of
+	// the files to the patch file named by --output-patch.
+	outputPatchFile
+
+	// outputDirectory means that we write the cleaned source code of each
+	// file to a mirrored path under the directory named by --output-dir.
+	outputDirectory
+)
+
+// mode is the output mode chosen by chooseOutputMode.
+var mode outputMode
+
+// chooseOutputMode decides the output mode from the options, returning an
+// error if the options conflict with one another.
+//
+// At most one of --write, --stdout, --diff, --output-patch, and --output-dir
+// may be used.
+// The reporting options --check, --list, --preview, and --json never modify
+// any files, so they can't be used with --write, and those that write to
+// stdout can't be combined with each other or with --stdout or --diff. If
+// no output option is given, we preview the changes to a single file by
+// printing its cleaned source, or otherwise print a diff.
+func chooseOutputMode(args []string) (outputMode, error) {
+	stdin := len(args) == 1 && args[0] == "-"
+	singleFile := len(args) == 1 && (stdin || (!archiveMode && isRegularFile(args[0])))
+
+	selected := 0
+	for _, set := range []bool{writeMode, stdoutMode, diffMode, outputPatch != "", outputDir != ""} {
+		if set {
+			selected++
+		}
+	}
+	stdoutReports := 0
+	for _, set := range []bool{listMode, previewMode, jsonMode} {
+		if set {
+			stdoutReports++
+		}
+	}
+
+	switch {
+	case selected > 1:
+		return outputNone, errors.New("Only one of the --write, --stdout, --diff, --output-patch, and --output-dir options can be used")
+	case writeMode && (checkMode || stdoutReports > 0):
+		return outputNone, errors.New("The --write option cannot be used with --check, --list, --preview, or --json")
+	case stdoutReports > 1:
+		return outputNone, errors.New("Only one of the --list, --preview, and --json options can be used")
+	case (stdoutMode || diffMode) && stdoutReports > 0:
+		return outputNone, errors.New("The --stdout and --diff options cannot be used with --list, --preview, or --json")
+	case stdoutMode && !singleFile:
+		return outputNone, errors.New("The --stdout option can only be used with a single file")
+	case tarMode && (!stdin || selected > 0 || stdoutReports > 0 || archiveMode):
+		return outputNone, errors.New("The --tar option can only be used when reading from stdin, and not with --write, --stdout, --diff, --output-patch, --output-dir, --list, --preview, --json, or --archive")
+	case archiveMode && (stdin || writeMode || stdoutMode || outputPatch != "" || outputDir != ""):
+		return outputNone, errors.New("The --archive option can only be used with files, and not with --write, --stdout, --output-patch, or --output-dir")
+	case failOnChange && !writeMode:
+		return outputNone, errors.New("The --fail-on-change option can only be used with --write")
+	case preserveMtime && !writeMode:
+		return outputNone, errors.New("The --preserve-mtime option can only be used with --write")
+	case stdin && (writeMode || outputPatch != "" || outputDir != "" || jsonMode || summaryFile != ""):
+		return outputNone, errors.New("The --write, --output-patch, --output-dir, --json, and --summary-json options cannot be used when reading from stdin")
+	}
+
+	switch {
+	case writeMode:
+		return outputInPlace, nil
+	case stdoutMode:
+		return outputStdout, nil
+	case diffMode:
+		return outputDiff, nil
+	case outputPatch != "":
+		return outputPatchFile, nil
+	case outputDir != "":
+		return outputDirectory, nil
+	case checkMode || stdoutReports > 0:
+		return outputNone, nil
+	case singleFile:
+		return outputStdout, nil
+	default:
+		return outputDiff, nil
+	}
+}
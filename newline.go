@@ -0,0 +1,48 @@
+package main
+
+import (
+	"bytes"
+)
+
+var (
+	crlf = []byte("\r\n")
+	lf   = []byte("\n")
+)
+
+// usesCRLF returns true if most of the lines in the given source code end
+// with CRLF rather than just LF, as is common for files checked out on
+// Windows.
+func usesCRLF(src []byte) bool {
+	crlfs := bytes.Count(src, crlf)
+	lfs := bytes.Count(src, lf) - crlfs
+	return crlfs > lfs
+}
+
+// toCRLF converts any lines in the given source code that end with just LF
+// to end with CRLF instead.
+//
+// hclwrite preserves the line endings of the source it parsed, so this is
+// needed only for any new newlines we've introduced while cleaning, so that
+// the result doesn't have mixed line endings.
+func toCRLF(src []byte) []byte {
+	if bytes.Count(src, lf) == bytes.Count(src, crlf) {
+		// Already all CRLF, so nothing to do.
+		return src
+	}
+	ret := make([]byte, 0, len(src)+bytes.Count(src, lf))
+	for len(src) > 0 {
+		i := bytes.IndexByte(src, '\n')
+		if i < 0 {
+			ret = append(ret, src...)
+			break
+		}
+		line := src[:i]
+		ret = append(ret, line...)
+		if len(line) == 0 || line[len(line)-1] != '\r' {
+			ret = append(ret, '\r')
+		}
+		ret = append(ret, '\n')
+		src = src[i+1:]
+	}
+	return ret
+}
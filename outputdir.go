@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// outputDir is the name of the directory given in the --output-dir option,
+// in which case we write the cleaned content of each file to a mirrored
+// path under that directory, rather than modifying the original files.
+var outputDir string
+
+// outputFullPaths is set by processArgs if there are several arguments,
+// after expanding any patterns, in which case the paths under outputDir
+// include the path of the argument too, because otherwise the paths of
+// files relative to different arguments could be the same.
+var outputFullPaths bool
+
+// outputPath returns the path under outputDir at which to write the file
+// with the given name, which was found by visiting the given argument.
+//
+// The result has the same path relative to outputDir as the file has to the
+// argument, or if the argument was the file itself then it has the same name
+// directly inside outputDir. If outputFullPaths is set, the result instead
+// has the same path relative to outputDir as the file has to the current
+// working directory, which must contain it.
+func outputPath(root, fn string) (string, error) {
+	if outputFullPaths {
+		rel := filepath.Clean(fn)
+		if filepath.IsAbs(rel) || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+			return "", errors.New("it is outside the current working directory, so it has no path under the output directory when there are several arguments")
+		}
+		return filepath.Join(outputDir, rel), nil
+	}
+	rel, err := filepath.Rel(root, fn)
+	if err != nil {
+		return "", err
+	}
+	if rel == "." {
+		rel = filepath.Base(fn)
+	}
+	return filepath.Join(outputDir, rel), nil
+}
+
+// writeOutputFile writes the given source code for the file with the given
+// name, which was found by visiting the given argument, to its path under
+// outputDir, creating any intermediate directories. The new file has the
+// same mode as the original, which is described by the given info.
+func writeOutputFile(root, fn string, src []byte, info os.FileInfo) error {
+	outFn, err := outputPath(root, fn)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(outFn), 0755); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(outFn, src, info.Mode().Perm())
+}
+
+// isOutputDir returns true if the given path refers to outputDir, so that
+// we can avoid visiting the files we're writing there if it is inside one
+// of the directories we're cleaning.
+func isOutputDir(fn string) bool {
+	if outputDir == "" {
+		return false
+	}
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return false
+	}
+	outAbs, err := filepath.Abs(outputDir)
+	return err == nil && abs == outAbs
+}
@@ -0,0 +1,17 @@
+//go:build windows || plan9
+// +build windows plan9
+
+package main
+
+import (
+	"os"
+)
+
+// copyOwner makes the file with the given name have the same owner and
+// group as the file described by the given info.
+//
+// File ownership doesn't work the same way on this platform, so this does
+// nothing.
+func copyOwner(fn string, info os.FileInfo) error {
+	return nil
+}
@@ -0,0 +1,27 @@
+//go:build !windows && !plan9
+// +build !windows,!plan9
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// copyOwner makes the file with the given name have the same owner and
+// group as the file described by the given info.
+//
+// This is best-effort: if we don't have permission to change the owner,
+// which is typically the case when not running as root, we leave the file
+// owned by the current user.
+func copyOwner(fn string, info os.FileInfo) error {
+	st, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return nil
+	}
+	err := os.Chown(fn, int(st.Uid), int(st.Gid))
+	if err != nil && os.IsPermission(err) {
+		return nil
+	}
+	return err
+}
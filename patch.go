@@ -0,0 +1,53 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"sort"
+)
+
+// outputPatch is the name of the file given in the --output-patch option,
+// in which case we write a patch describing all of the changes to that
+// file, rather than modifying any files.
+var outputPatch string
+
+// patches accumulates the diff for each file to include in the patch file,
+// keyed by filename. resultsMu must be held while accessing it.
+var patches = make(map[string][]byte)
+
+// addPatch records the changes to the file with the given name for
+// inclusion in the patch file.
+func addPatch(fn string, oldSrc, newSrc []byte) error {
+	// We use the a/ and b/ prefixes that git uses, so that the result can
+	// be applied with "git apply" as well as "patch -p1".
+	name := filepath.ToSlash(fn)
+	var buf bytes.Buffer
+	if err := writeDiff(&buf, "a/"+name, "b/"+name, oldSrc, newSrc); err != nil {
+		return err
+	}
+
+	resultsMu.Lock()
+	patches[fn] = buf.Bytes()
+	resultsMu.Unlock()
+	return nil
+}
+
+// writePatchFile writes all of the changes recorded by addPatch to the
+// file named in the --output-patch option, ordered by filename.
+func writePatchFile() error {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	names := make([]string, 0, len(patches))
+	for fn := range patches {
+		names = append(names, fn)
+	}
+	sort.Strings(names)
+
+	var buf bytes.Buffer
+	for _, fn := range names {
+		buf.Write(patches[fn])
+	}
+	return ioutil.WriteFile(outputPatch, buf.Bytes(), 0644)
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+)
+
+// progressMode is set by the --progress option, and is "auto" to show
+// the progress of a run when stderr is a terminal, "always" to show it
+// regardless, or "never" to not show it at all.
+var progressMode string
+
+// filesFound and filesDone count the files that the walker has queued and
+// that the workers have finished processing, for the progress display.
+// They must be accessed atomically.
+var filesFound, filesDone int64
+
+// progressInterval is how often the progress display is updated.
+const progressInterval = time.Second
+
+// startProgress starts periodically writing the progress of the run to
+// stderr, if enabled by --progress, and returns a function that stops it.
+//
+// When stderr is a terminal, each update replaces the previous one on the
+// same line, which is cleared when the display stops. Otherwise, each update
+// is written on a separate line.
+func startProgress() (stop func()) {
+	tty := isTerminal(os.Stderr)
+	if progressMode == "never" || (progressMode == "auto" && !tty) {
+		return func() {}
+	}
+
+	done := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(progressInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				if tty {
+					os.Stderr.WriteString("\r\x1b[K")
+				}
+				return
+			case <-ticker.C:
+				msg := fmt.Sprintf("Processed %d/%d files", atomic.LoadInt64(&filesDone), atomic.LoadInt64(&filesFound))
+				if tty {
+					fmt.Fprintf(os.Stderr, "\r\x1b[K%s", msg)
+				} else {
+					fmt.Fprintln(os.Stderr, msg)
+				}
+			}
+		}
+	}()
+	return func() {
+		close(done)
+		<-stopped
+	}
+}
+
+// isTerminal returns true if the given file seems to be a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
@@ -0,0 +1,394 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/hashicorp/hcl/v2"
+
+	"github.com/apparentlymart/terraform-clean-syntax/clean"
+)
+
+// jsonMode is set by the --json option, in which case we write a JSON
+// description of the results to stdout instead of logging them.
+var jsonMode bool
+
+// adviseMode is set by the --advise option, in which case we log advice
+// about the legacy syntax that we left unchanged in each file at the end.
+var adviseMode bool
+
+// advice records the advisories for each file that has any, for adviseMode.
+// resultsMu must be held while accessing it.
+var advice = map[string][]clean.Advisory{}
+
+// jsonResults accumulates the results to write in JSON mode. resultsMu must
+// be held while accessing it.
+var jsonResults []jsonResult
+
+// jsonResult is the JSON representation of the result of processing a
+// single file, which is either a description of the changes or an error.
+type jsonResult struct {
+	Path    string       `json:"path,omitempty"`
+	Changed *bool        `json:"changed,omitempty"`
+	Changes *clean.Stats `json:"changes,omitempty"`
+	Error   string       `json:"error,omitempty"`
+	Skipped string       `json:"skipped,omitempty"`
+}
+
+// totalStats accumulates the changes made to all files, or the changes that
+// would be made in modes that don't modify files, and filesWithChanges
+// counts the files that contributed to it. resultsMu must be held while
+// accessing either.
+var totalStats clean.Stats
+var filesWithChanges int
+
+// statsByDir is set by the --stats-by-dir option, in which case we log the
+// number of files changed and the number of changes made in each directory
+// at the end.
+var statsByDir bool
+
+// dirStats accumulates the results for each directory, for statsByDir.
+// resultsMu must be held while accessing it.
+var dirStats = map[string]*dirResult{}
+
+// dirResult counts the files processed in a single directory, the files
+// among them with changes, and the total number of changes to those files.
+type dirResult struct {
+	files            int
+	filesWithChanges int
+	changes          int
+}
+
+// reportUnchanged is set by the --report-unchanged option, in which case we
+// log each file that we found not to need any changes.
+var reportUnchanged bool
+
+// reportResult records the result of successfully processing the file with
+// the given name, for inclusion in the summary or the JSON output.
+func reportResult(fn string, changed bool, stats clean.Stats) {
+	if !changed && reportUnchanged {
+		logInfo("No changes needed: %s", fn)
+	}
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	if changed {
+		totalStats.Add(stats)
+		filesWithChanges++
+	}
+	if statsByDir {
+		dir := filepath.Dir(fn)
+		result := dirStats[dir]
+		if result == nil {
+			result = &dirResult{}
+			dirStats[dir] = result
+		}
+		result.files++
+		if changed {
+			result.filesWithChanges++
+			result.changes += stats.Total()
+		}
+	}
+	if jsonMode {
+		jsonResults = append(jsonResults, jsonResult{
+			Path:    fn,
+			Changed: &changed,
+			Changes: &stats,
+		})
+	}
+}
+
+// hadErrors is set by reportError, so that we can exit with a non-zero
+// status code at the end, and errorCount counts the errors reported.
+// resultsMu must be held while accessing either.
+var hadErrors bool
+var errorCount int
+
+// reportError reports an error relating to the file with the given name,
+// either by logging it or by recording it for the JSON output.
+func reportError(fn string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	hadErrors = true
+	errorCount++
+	if !jsonMode {
+		log.Print(msg)
+		return
+	}
+	jsonResults = append(jsonResults, jsonResult{
+		Path:  fn,
+		Error: msg,
+	})
+}
+
+// reportSkipped reports that we didn't clean the file with the given name
+// because of a limit such as --max-file-size or --per-file-timeout, either
+// by logging it or by recording it for the JSON output. Unlike the reasons
+// logged by logSkip, this means that the file may still need cleaning, so
+// it's logged even in quiet mode.
+func reportSkipped(fn string, format string, args ...interface{}) {
+	msg := fmt.Sprintf(format, args...)
+
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	filesSkipped++
+	if !jsonMode {
+		log.Print(msg)
+		return
+	}
+	jsonResults = append(jsonResults, jsonResult{
+		Path:    fn,
+		Skipped: msg,
+	})
+}
+
+// reportDiagnostics reports each of the given diagnostics as an error
+// relating to the file with the given name, whose source code is given so
+// that the logged messages can include a snippet of it.
+func reportDiagnostics(fn string, src []byte, diags hcl.Diagnostics) {
+	if jsonMode {
+		// The JSON output has a single line for each error, so we'll leave
+		// out the snippets there.
+		for _, diag := range diags {
+			if diag.Subject != nil {
+				reportError(fn, "[%s:%d] %s: %s", diag.Subject.Filename, diag.Subject.Start.Line, diag.Summary, diag.Detail)
+			} else {
+				reportError(fn, "%s: %s", diag.Summary, diag.Detail)
+			}
+		}
+		return
+	}
+
+	files := map[string]*hcl.File{
+		fn: {Bytes: src},
+	}
+	for _, diag := range diags {
+		var buf strings.Builder
+		wr := hcl.NewDiagnosticTextWriter(&buf, files, 0, false)
+		if err := wr.WriteDiagnostic(diag); err != nil {
+			reportError(fn, "%s: %s", diag.Summary, diag.Detail)
+			continue
+		}
+		reportError(fn, "%s", strings.TrimRight(buf.String(), "\n"))
+	}
+}
+
+// logSkip logs that we skipped the file or directory with the given name
+// for the given reason, but only in verbose mode, because the reasons are
+// usually unsurprising.
+func logSkip(fn string, reason string) {
+	if !verboseMode || jsonMode {
+		return
+	}
+	log.Printf("Skipping %q: %s", fn, reason)
+}
+
+// logInfo logs an informational message, unless we're in JSON or quiet mode.
+func logInfo(format string, args ...interface{}) {
+	if jsonMode || quietMode {
+		return
+	}
+	log.Printf(format, args...)
+}
+
+// logSummary logs a summary of the results of processing all of the files.
+func logSummary() {
+	switch mode {
+	case outputInPlace:
+		log.Printf("Cleaned %d of %d files", filesChanged, filesProcessed)
+	case outputDirectory:
+		log.Printf("Cleaned %d of %d files, writing copies to %s", filesChanged, filesProcessed, outputDir)
+	}
+	log.Printf(
+		"Unwrapped %d interpolations, converted %d type constraints, unquoted %d provider references, and replaced %d function calls with index syntax across %d files",
+		totalStats.Interpolations, totalStats.TypeConstraints, totalStats.ProviderRefs, totalStats.IndexCalls, filesWithChanges,
+	)
+	if totalStats.Splats > 0 {
+		log.Printf("Replaced %d legacy splat expressions", totalStats.Splats)
+	}
+	if totalStats.CollectionCalls > 0 {
+		log.Printf("Replaced %d calls to the list and map functions", totalStats.CollectionCalls)
+	}
+	if totalStats.CustomTransforms > 0 {
+		log.Printf("Applied %d custom transforms", totalStats.CustomTransforms)
+	}
+	if filesInvalid > 0 {
+		log.Printf("Failed to parse %d files, which were not cleaned", filesInvalid)
+	}
+	if filesUnsafe > 0 {
+		log.Printf("Can't safely rewrite %d files, which were not cleaned", filesUnsafe)
+	}
+	if filesSkipped > 0 {
+		log.Printf("Skipped %d files, which were not cleaned", filesSkipped)
+	}
+}
+
+// writeJSONResults writes all of the results recorded so far to the given
+// writer as a JSON array, ordered by path.
+func writeJSONResults(w io.Writer) error {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	// Files are processed concurrently, so we'll sort the results to make
+	// the output consistent. The sort is stable so that multiple errors
+	// for the same file stay in the order they were reported.
+	sort.SliceStable(jsonResults, func(i, j int) bool {
+		return jsonResults[i].Path < jsonResults[j].Path
+	})
+
+	results := jsonResults
+	if results == nil {
+		// We always want to produce an array, even if it's empty.
+		results = []jsonResult{}
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(results)
+}
+
+// summaryFile is the name of the file given in the --summary-json option,
+// in which case we write a JSON summary of the results to that file.
+var summaryFile string
+
+// jsonSummary is the JSON representation of the summary of the results of
+// processing all of the files, as written by writeSummaryFile.
+type jsonSummary struct {
+	FilesProcessed   int         `json:"files_processed"`
+	FilesWithChanges int         `json:"files_with_changes"`
+	FilesWritten     int         `json:"files_written"`
+	FilesInvalid     int         `json:"files_invalid"`
+	FilesUnsafe      int         `json:"files_unsafe"`
+	FilesSkipped     int         `json:"files_skipped"`
+	Errors           int         `json:"errors"`
+	Changes          clean.Stats `json:"changes"`
+}
+
+// writeSummaryFile writes a JSON summary of the results of processing all
+// of the files to the file named in the --summary-json option.
+func writeSummaryFile() error {
+	resultsMu.Lock()
+	summary := jsonSummary{
+		FilesProcessed:   filesProcessed,
+		FilesWithChanges: filesWithChanges,
+		FilesWritten:     filesChanged,
+		FilesInvalid:     filesInvalid,
+		FilesUnsafe:      filesUnsafe,
+		FilesSkipped:     filesSkipped,
+		Errors:           errorCount,
+		Changes:          totalStats,
+	}
+	resultsMu.Unlock()
+
+	src, err := json.MarshalIndent(summary, "", "  ")
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(summaryFile, append(src, '\n'), 0644)
+}
+
+// logChanges logs that the file with the given name was changed, along with
+// the location of each of the given changes within it.
+func logChanges(fn string, changes []clean.Change) {
+	// We build a single message so that the lines for concurrently-processed
+	// files aren't interleaved.
+	var buf strings.Builder
+	fmt.Fprintf(&buf, "Made changes: %s", fn)
+	for _, change := range changes {
+		fmt.Fprintf(&buf, "\n  %s:%d: %s", fn, change.Range.Start.Line, describeStats(change.Stats))
+	}
+	log.Print(buf.String())
+}
+
+// recordAdvice records the given advisories about the file with the given
+// name, to be logged at the end by logAdvice.
+func recordAdvice(fn string, advisories []clean.Advisory) {
+	if len(advisories) == 0 {
+		return
+	}
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+	advice[fn] = append(advice[fn], advisories...)
+}
+
+// logAdvice logs all of the advisories recorded so far, grouped by file and
+// ordered by filename.
+func logAdvice() {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	fns := make([]string, 0, len(advice))
+	for fn := range advice {
+		fns = append(fns, fn)
+	}
+	sort.Strings(fns)
+	color := useColor(os.Stderr)
+	for _, fn := range fns {
+		var buf strings.Builder
+		fmt.Fprintf(&buf, "Left unchanged: %s", colored(color, colorBold, fn))
+		for _, advisory := range advice[fn] {
+			loc := fmt.Sprintf("%s:%d:", fn, advisory.Range.Start.Line)
+			fmt.Fprintf(&buf, "\n  %s %s", colored(color, colorYellow, loc), advisory.Message)
+		}
+		log.Print(buf.String())
+	}
+}
+
+// logStatsByDir logs the results recorded for each directory, ordered by
+// path.
+func logStatsByDir() {
+	resultsMu.Lock()
+	defer resultsMu.Unlock()
+
+	dirs := make([]string, 0, len(dirStats))
+	for dir := range dirStats {
+		dirs = append(dirs, dir)
+	}
+	sort.Strings(dirs)
+	var buf strings.Builder
+	buf.WriteString("Changes by directory:")
+	for _, dir := range dirs {
+		result := dirStats[dir]
+		fmt.Fprintf(&buf, "\n  %s: %d of %d files changed, with %d changes", dir, result.filesWithChanges, result.files, result.changes)
+	}
+	log.Print(buf.String())
+}
+
+// describeStats returns a short description of the changes counted in the
+// given stats, for use in log messages.
+func describeStats(stats clean.Stats) string {
+	var parts []string
+	if stats.Interpolations > 0 {
+		parts = append(parts, fmt.Sprintf("unwrapped %d interpolations", stats.Interpolations))
+	}
+	if stats.TypeConstraints > 0 {
+		parts = append(parts, fmt.Sprintf("converted %d type constraints", stats.TypeConstraints))
+	}
+	if stats.ProviderRefs > 0 {
+		parts = append(parts, fmt.Sprintf("unquoted %d provider references", stats.ProviderRefs))
+	}
+	if stats.IndexCalls > 0 {
+		parts = append(parts, fmt.Sprintf("replaced %d function calls with index syntax", stats.IndexCalls))
+	}
+	if stats.Splats > 0 {
+		parts = append(parts, fmt.Sprintf("replaced %d legacy splat expressions", stats.Splats))
+	}
+	if stats.CollectionCalls > 0 {
+		parts = append(parts, fmt.Sprintf("replaced %d calls to the list and map functions", stats.CollectionCalls))
+	}
+	if stats.CustomTransforms > 0 {
+		parts = append(parts, fmt.Sprintf("applied %d custom transforms", stats.CustomTransforms))
+	}
+	return strings.Join(parts, ", ")
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+)
+
+// rootDir is the directory given in the --root option, in which case we
+// report the paths of files relative to that directory.
+var rootDir string
+
+// changeToRoot makes rootDir the working directory, so that the paths of
+// the files we find, and so all of the paths we report, are relative to it.
+// It returns the given arguments rewritten to be relative to rootDir, so
+// that they still refer to the same files.
+//
+// The options that name files to write are made absolute first, so that
+// they also still refer to the same files.
+func changeToRoot(args []string) ([]string, error) {
+	absRoot, err := filepath.Abs(rootDir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, opt := range []*string{&outputPatch, &outputDir, &summaryFile} {
+		if *opt == "" {
+			continue
+		}
+		if *opt, err = filepath.Abs(*opt); err != nil {
+			return nil, err
+		}
+	}
+
+	ret := make([]string, len(args))
+	for i, arg := range args {
+		abs, err := filepath.Abs(arg)
+		if err != nil {
+			return nil, err
+		}
+		if ret[i], err = filepath.Rel(absRoot, abs); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := os.Chdir(absRoot); err != nil {
+		return nil, err
+	}
+	return ret, nil
+}
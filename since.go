@@ -0,0 +1,86 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"os/exec"
+	"path/filepath"
+	"strings"
+)
+
+// sinceRef is set by the --since option, and is a git ref identifying the
+// commit to compare with. If set, we process only the files that have
+// changed since that commit.
+var sinceRef string
+
+// changedFiles is the set of files that have changed since sinceRef, keyed
+// by absolute path, or nil if we're not filtering by changes. It is
+// populated by loadChangedFiles before the walk begins.
+var changedFiles map[string]struct{}
+
+// loadChangedFiles asks git which files in the current working directory
+// have changed since the given ref, and records them in changedFiles.
+//
+// Files that git isn't tracking yet, and that aren't ignored, are new since
+// any ref, so they count as changed too.
+func loadChangedFiles(ref string) error {
+	changed, err := gitFiles("diff", "--name-only", "-z", "--relative", "--diff-filter=d", ref, "--")
+	if err != nil {
+		return err
+	}
+	untracked, err := gitFiles("ls-files", "--others", "--exclude-standard", "-z")
+	if err != nil {
+		return err
+	}
+
+	changedFiles = make(map[string]struct{})
+	for _, fn := range append(changed, untracked...) {
+		abs, err := filepath.Abs(fn)
+		if err != nil {
+			return err
+		}
+		changedFiles[abs] = struct{}{}
+	}
+	return nil
+}
+
+// gitFiles runs git with the given arguments, which must include -z, and
+// returns the file names that it prints, relative to the current working
+// directory.
+//
+// Without -z, git quotes any name containing unusual characters, such as
+// non-ASCII letters, so it wouldn't match the name of the file on disk.
+func gitFiles(args ...string) ([]string, error) {
+	cmd := exec.Command("git", args...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		if msg := strings.TrimSpace(stderr.String()); msg != "" {
+			return nil, fmt.Errorf("%s: %s", err, msg)
+		}
+		return nil, err
+	}
+
+	var ret []string
+	for _, name := range strings.Split(string(out), "\x00") {
+		if name != "" {
+			ret = append(ret, name)
+		}
+	}
+	return ret, nil
+}
+
+// isChanged returns true if the given file has changed since sinceRef, or
+// if we're not filtering by changes at all.
+func isChanged(fn string) bool {
+	if changedFiles == nil {
+		return true
+	}
+	abs, err := filepath.Abs(fn)
+	if err != nil {
+		return false
+	}
+	_, ok := changedFiles[abs]
+	return ok
+}
@@ -0,0 +1,249 @@
+package main
+
+import (
+	"bytes"
+	"sort"
+
+	"github.com/hashicorp/hcl/v2"
+	"github.com/hashicorp/hcl/v2/hclsyntax"
+)
+
+// sortAttrs is set by the --sort-attrs option, in which case we sort the
+// arguments in each body by name.
+var sortAttrs bool
+
+// pinnedAttrs gives the arguments that are conventionally written before all
+// of the others in a block, in the order they're written.
+var pinnedAttrs = map[string]int{
+	"count":    0,
+	"for_each": 1,
+	"provider": 2,
+}
+
+// sortAttributes returns a copy of the given source code with the arguments
+// in each body sorted by name, apart from those in pinnedAttrs, which are
+// moved before the others.
+//
+// The result is not formatted, so the caller should format it if it differs
+// from the given source code, to realign the arguments that were moved.
+//
+// Only consecutive arguments are sorted together, so a nested block or a
+// blank line between two arguments keeps them apart. Any comment lines
+// directly above an argument move along with it, as does a comment at the
+// end of its last line.
+func sortAttributes(src []byte, fn string) []byte {
+	f, diags := hclsyntax.ParseConfig(src, fn, hcl.Pos{Line: 1, Column: 1})
+	if diags.HasErrors() {
+		// Shouldn't happen, since the caller has already parsed src, but
+		// we'll just leave it unchanged if so.
+		return src
+	}
+	body, ok := f.Body.(*hclsyntax.Body)
+	if !ok {
+		return src
+	}
+
+	// Our line-based approach needs every argument to end with a newline,
+	// including one at the very end of the file. If we move the last
+	// argument then the newline stays with it, because the file shouldn't
+	// end in the middle of a line.
+	orig := src
+	if !bytes.HasSuffix(src, []byte("\n")) {
+		src = append(append([]byte(nil), src...), '\n')
+	}
+
+	var edits []sortEdit
+	collectSortEdits(src, body, 0, len(src), &edits)
+	if len(edits) == 0 {
+		return orig
+	}
+
+	// The edits don't overlap, so applying them from the end of the source
+	// keeps the offsets of the remaining ones valid.
+	sort.Slice(edits, func(i, j int) bool {
+		return edits[i].start > edits[j].start
+	})
+	ret := src
+	for _, edit := range edits {
+		next := make([]byte, 0, len(ret))
+		next = append(next, ret[:edit.start]...)
+		next = append(next, edit.replacement...)
+		next = append(next, ret[edit.end:]...)
+		ret = next
+	}
+	return ret
+}
+
+// sortEdit describes the replacement of a run of consecutive arguments with
+// the same arguments in sorted order.
+type sortEdit struct {
+	start, end  int
+	replacement []byte
+}
+
+// sortUnit is an argument within a run, along with the comment lines above
+// it, covering whole lines of the source code.
+type sortUnit struct {
+	name       string
+	start, end int
+}
+
+// collectSortEdits appends the edits needed to sort the arguments in the
+// given body, and in each of its nested blocks, to the given edits.
+//
+// The content of the body lies between the given start and end offsets. The
+// body is left alone if any of its items share a line with anything else,
+// as in a block written on a single line.
+func collectSortEdits(src []byte, body *hclsyntax.Body, start, end int, edits *[]sortEdit) {
+	type item struct {
+		name       string
+		attr       bool
+		start, end int
+	}
+	items := make([]item, 0, len(body.Attributes)+len(body.Blocks))
+	for name, attr := range body.Attributes {
+		items = append(items, item{
+			name:  name,
+			attr:  true,
+			start: lineStart(src, attr.SrcRange.Start.Byte),
+			end:   lineEnd(src, attr.SrcRange.End.Byte),
+		})
+	}
+	for _, block := range body.Blocks {
+		rng := block.Range()
+		items = append(items, item{
+			start: lineStart(src, rng.Start.Byte),
+			end:   lineEnd(src, rng.End.Byte),
+		})
+	}
+	sort.Slice(items, func(i, j int) bool {
+		return items[i].start < items[j].start
+	})
+
+	var run []sortUnit
+	var runEdits []sortEdit
+	prevEnd := start
+	for _, item := range items {
+		if item.start < prevEnd || item.end > end {
+			return
+		}
+		gap := src[prevEnd:item.start]
+		if !item.attr || hasBlankLine(gap) {
+			runEdits = appendSortEdit(runEdits, src, run)
+			run = nil
+		}
+		if item.attr {
+			// Between the items of a body there can only be comments and
+			// whitespace, so any non-blank lines directly above the
+			// argument are comments that belong with it.
+			unitStart := prevEnd
+			if len(run) == 0 {
+				unitStart += commentStart(gap)
+			}
+			run = append(run, sortUnit{
+				name:  item.name,
+				start: unitStart,
+				end:   item.end,
+			})
+		}
+		prevEnd = item.end
+	}
+	runEdits = appendSortEdit(runEdits, src, run)
+	*edits = append(*edits, runEdits...)
+
+	for _, block := range body.Blocks {
+		bodyStart := lineEnd(src, block.OpenBraceRange.End.Byte)
+		bodyEnd := block.CloseBraceRange.Start.Byte
+		if bodyStart > bodyEnd {
+			// The block is written on a single line.
+			continue
+		}
+		collectSortEdits(src, block.Body, bodyStart, bodyEnd, edits)
+	}
+}
+
+// appendSortEdit appends an edit to sort the given run of arguments to the
+// given edits, unless it's already sorted.
+func appendSortEdit(edits []sortEdit, src []byte, run []sortUnit) []sortEdit {
+	if len(run) < 2 {
+		return edits
+	}
+	sorted := make([]sortUnit, len(run))
+	copy(sorted, run)
+	sort.SliceStable(sorted, func(i, j int) bool {
+		return attrLess(sorted[i].name, sorted[j].name)
+	})
+	changed := false
+	var buf bytes.Buffer
+	for i, unit := range sorted {
+		if unit != run[i] {
+			changed = true
+		}
+		buf.Write(src[unit.start:unit.end])
+	}
+	if !changed {
+		return edits
+	}
+	return append(edits, sortEdit{
+		start:       run[0].start,
+		end:         run[len(run)-1].end,
+		replacement: buf.Bytes(),
+	})
+}
+
+// attrLess returns true if the argument with name a should be written before
+// the argument with name b.
+func attrLess(a, b string) bool {
+	pa, aPinned := pinnedAttrs[a]
+	pb, bPinned := pinnedAttrs[b]
+	switch {
+	case aPinned && bPinned:
+		return pa < pb
+	case aPinned != bPinned:
+		return aPinned
+	default:
+		return a < b
+	}
+}
+
+// commentStart returns the offset within the given gap between two items of
+// a body at which the comment lines directly above the second item start,
+// or the length of the gap if there are none.
+func commentStart(gap []byte) int {
+	start := len(gap)
+	for start > 0 {
+		lineBegin := bytes.LastIndexByte(gap[:start-1], '\n') + 1
+		if len(bytes.TrimSpace(gap[lineBegin:start])) == 0 {
+			break
+		}
+		start = lineBegin
+	}
+	return start
+}
+
+// hasBlankLine returns true if the given gap between two items of a body
+// includes a blank line.
+func hasBlankLine(gap []byte) bool {
+	for _, line := range bytes.SplitAfter(gap, []byte("\n")) {
+		if len(line) > 0 && len(bytes.TrimSpace(line)) == 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// lineStart returns the offset of the start of the line containing the
+// given offset.
+func lineStart(src []byte, offset int) int {
+	return bytes.LastIndexByte(src[:offset], '\n') + 1
+}
+
+// lineEnd returns the offset just after the newline that ends the line
+// containing the given offset.
+func lineEnd(src []byte, offset int) int {
+	i := bytes.IndexByte(src[offset:], '\n')
+	if i < 0 {
+		return len(src)
+	}
+	return offset + i + 1
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"archive/tar"
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// tarMode is set by the --tar option, in which case we read a tar archive
+// from stdin and write a copy of it to stdout in which each of the
+// configuration files has been cleaned, for use in pipelines where the files
+// aren't on a writable filesystem.
+var tarMode bool
+
+// processTar copies the tar archive on stdin to stdout, cleaning each of
+// the configuration files inside it. Each file is named in messages by its
+// path within the archive.
+//
+// Every entry is copied with its original header, apart from the size of
+// each file that we change. A file that we can't clean, such as because it
+// is invalid, is copied unchanged after reporting the error.
+func processTar() {
+	tr := tar.NewReader(os.Stdin)
+	tw := tar.NewWriter(os.Stdout)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			reportError("", "Failed to read tar archive from stdin: %s", err)
+			os.Exit(exitError)
+		}
+
+		var content io.Reader = tr
+		fn := archiveEntryPath(hdr.Name)
+		if (hdr.Typeflag == tar.TypeReg || hdr.Typeflag == tar.TypeRegA) && !skipArchiveEntry(fn, uint64(hdr.Size)) {
+			src, err := ioutil.ReadAll(tr)
+			if err != nil {
+				reportError(fn, "Failed to read %q from tar archive: %s", fn, err)
+				os.Exit(exitError)
+			}
+			newSrc := processTarEntry(fn, src)
+			hdr.Size = int64(len(newSrc))
+			content = bytes.NewReader(newSrc)
+		}
+
+		if err := tw.WriteHeader(hdr); err != nil {
+			reportError(fn, "Failed to write %q to tar archive: %s", fn, err)
+			os.Exit(exitError)
+		}
+		if _, err := io.Copy(tw, content); err != nil {
+			reportError(fn, "Failed to write %q to tar archive: %s", fn, err)
+			os.Exit(exitError)
+		}
+	}
+	if err := tw.Close(); err != nil {
+		reportError("", "Failed to write tar archive to stdout: %s", err)
+		os.Exit(exitError)
+	}
+	if !quietMode {
+		logSummary()
+	}
+}
+
+// processTarEntry cleans the given source code of the file from a tar
+// archive that is named by the given name in messages, returning the
+// content to write in its place.
+func processTarEntry(fn string, src []byte) []byte {
+	resultsMu.Lock()
+	filesProcessed++
+	resultsMu.Unlock()
+
+	newSrc, stats, changes, ok := cleanSource(src, fn)
+	if !ok {
+		return src
+	}
+	if bytes.Equal(newSrc, src) {
+		reportResult(fn, false, stats)
+		return src
+	}
+
+	if checkMode {
+		resultsMu.Lock()
+		uncleanFiles = append(uncleanFiles, fn)
+		uncleanChanges[fn] = stats.Total()
+		resultsMu.Unlock()
+	}
+	reportResult(fn, true, stats)
+	if verboseMode {
+		logChanges(fn, changes)
+	}
+	return newSrc
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"time"
+
+	"github.com/hashicorp/hcl/v2"
+
+	"github.com/apparentlymart/terraform-clean-syntax/clean"
+)
+
+// perFileTimeout is set by the --per-file-timeout option, and is the longest
+// we'll spend applying the cleaning rules to a single file, or zero for no
+// limit.
+var perFileTimeout time.Duration
+
+// applyRulesTimeout is like applyRules, but returns false if it doesn't
+// finish within perFileTimeout, in which case the other results are not
+// usable.
+//
+// We have no way to interrupt applyRules, so in that case it continues in the
+// background until it finishes and its result is discarded. That's safe only
+// because applyRules doesn't report anything or modify any shared state.
+func applyRulesTimeout(src []byte, fn string) ([]byte, clean.Result, hcl.Diagnostics, bool) {
+	if perFileTimeout == 0 {
+		newSrc, result, diags := applyRules(src, fn)
+		return newSrc, result, diags, true
+	}
+
+	type applyResult struct {
+		newSrc   []byte
+		result   clean.Result
+		diags    hcl.Diagnostics
+		panicVal interface{}
+	}
+	// The channel is buffered so that the goroutine can still finish if we
+	// stop waiting for it.
+	done := make(chan applyResult, 1)
+	go func() {
+		var ret applyResult
+		defer func() {
+			// A panic can only be recovered in the goroutine where it
+			// happened, so we pass it back to be raised again in the
+			// caller, which will report it.
+			ret.panicVal = recover()
+			done <- ret
+		}()
+		ret.newSrc, ret.result, ret.diags = applyRules(src, fn)
+	}()
+
+	timer := time.NewTimer(perFileTimeout)
+	defer timer.Stop()
+	select {
+	case ret := <-done:
+		if ret.panicVal != nil {
+			panic(ret.panicVal)
+		}
+		return ret.newSrc, ret.result, ret.diags, true
+	case <-timer.C:
+		return nil, clean.Result{}, nil, false
+	}
+}
@@ -0,0 +1,272 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+
+	"github.com/bmatcuk/doublestar"
+)
+
+// walker visits the files and directories given as arguments, finding the
+// files that we should clean.
+//
+// Directories are visited sequentially by a single goroutine, but each file
+// found is sent to the queue channel so that it can be processed
+// concurrently with the rest of the walk.
+//
+// If ctx is cancelled, the walker stops visiting new files and directories.
+//
+// root is the argument that the walker is currently visiting, which is
+// recorded in each job so that --output-dir can mirror the paths below it.
+//
+// visitedFiles records the real paths of the files found, which we use to
+// avoid processing the same file twice when following symbolic links.
+type walker struct {
+	ctx          context.Context
+	root         string
+	visited      visitedDirs
+	visitedFiles map[string]struct{}
+	queue        chan<- fileJob
+}
+
+// fileJob describes a file that a walker has found to be processed.
+type fileJob struct {
+	fn   string
+	root string
+	info os.FileInfo
+}
+
+func newWalker(ctx context.Context, queue chan<- fileJob) *walker {
+	return &walker{
+		ctx:          ctx,
+		visited:      make(visitedDirs),
+		visitedFiles: make(map[string]struct{}),
+		queue:        queue,
+	}
+}
+
+// processItem visits the file or directory with the given name, queuing it
+// for processing if it is a file we should clean, or visiting its contents
+// if it is a directory.
+//
+// depth is the number of directories we visited to find the item, so it is
+// zero for a name given directly as an argument.
+func (w *walker) processItem(fn string, ignores ignoreRules, depth int) {
+	if w.ctx.Err() != nil {
+		return
+	}
+	fn = filepath.Clean(fn)
+
+	if isExcluded(fn) {
+		logSkip(fn, "matches an --exclude pattern")
+		return
+	}
+	if isOutputDir(fn) {
+		logSkip(fn, "this is the --output-dir directory")
+		return
+	}
+
+	info, err := os.Lstat(fn)
+	if err != nil {
+		if depth > 0 && os.IsNotExist(err) {
+			// The item must've been deleted since we read its directory,
+			// which isn't a problem for us.
+			logInfo("Skipping %q: no longer exists", fn)
+			return
+		}
+		reportError(fn, "Failed to stat %q: %s", fn, err)
+		return
+	}
+
+	if info.Mode()&os.ModeSymlink != 0 {
+		if !followSymlinks {
+			logInfo("Skipping %q: symbolic link, which is only followed with --follow-symlinks", fn)
+			return
+		}
+		info, err = os.Stat(fn)
+		if err != nil {
+			if depth > 0 && os.IsNotExist(err) {
+				logInfo("Skipping %q: symbolic link to something that doesn't exist", fn)
+				return
+			}
+			reportError(fn, "Failed to follow symbolic link %q: %s", fn, err)
+			return
+		}
+	}
+
+	if info.IsDir() {
+		if !includeHidden && info.Name() != "." && info.Name() != ".." && strings.HasPrefix(info.Name(), ".") {
+			logSkip(fn, "hidden directory")
+			return
+		}
+		if id, ok := getFileID(info); ok {
+			if _, seen := w.visited[id]; seen {
+				logInfo("Skipping %q: already visited this directory", fn)
+				return
+			}
+			w.visited[id] = struct{}{}
+		}
+		w.processDir(fn, ignores, depth)
+	} else {
+		if !info.Mode().IsRegular() {
+			logInfo("Skipping %q: not a regular file or directory", fn)
+			return
+		}
+		if followSymlinks {
+			// The same file could be reachable both directly and through
+			// a symbolic link, and we mustn't process it twice. We can't
+			// use the file ID here, as we do for directories, because
+			// writeFileAtomic replaces the file with a new one.
+			if real, err := filepath.EvalSymlinks(fn); err == nil {
+				if _, seen := w.visitedFiles[real]; seen {
+					logInfo("Skipping %q: already visited this file", fn)
+					return
+				}
+				w.visitedFiles[real] = struct{}{}
+			}
+		}
+		if strings.HasSuffix(fn, ".tf.json") || strings.HasSuffix(fn, ".tfvars.json") {
+			// This tool only knows how to rewrite native syntax, but we
+			// mention these explicitly so it's clear that skipping them is
+			// intentional.
+			logInfo("Skipping %q: JSON configuration files are not supported", fn)
+			return
+		}
+		if !hasCleanableSuffix(fn) && !(depth == 0 && forceMode) {
+			logSkip(fn, "filename suffix is not one to process")
+			return
+		}
+		if !isIncluded(fn) {
+			logSkip(fn, "doesn't match any --include pattern")
+			return
+		}
+		if !isChanged(fn) {
+			logSkip(fn, "not changed since "+sinceRef)
+			return
+		}
+		atomic.AddInt64(&filesFound, 1)
+		w.queue <- fileJob{fn: fn, root: w.root, info: info}
+	}
+}
+
+// cleanableSuffixes are the filename suffixes of the files we will process,
+// to which main adds any suffixes given in --ext options.
+//
+// Variable definitions files don't contain any blocks, so only the rules
+// for cleaning argument values will apply to them. The same is true of the
+// blocks in test files and their mock data files, whose types have no
+// special meaning to the cleaning rules.
+var cleanableSuffixes = []string{".tf", ".tfvars", ".tftest.hcl", ".tfmock.hcl"}
+
+// hasCleanableSuffix returns true if the given filename has one of the
+// suffixes in cleanableSuffixes.
+func hasCleanableSuffix(fn string) bool {
+	for _, suffix := range cleanableSuffixes {
+		if strings.HasSuffix(fn, suffix) {
+			return true
+		}
+	}
+	return false
+}
+
+// isExcluded returns true if the given path matches any of the patterns
+// given in --exclude options.
+func isExcluded(fn string) bool {
+	for _, pattern := range excludePatterns {
+		// We validated the patterns in main, so we can ignore errors here.
+		if matched, _ := doublestar.PathMatch(pattern, fn); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// isIncluded returns true if the given path of a file matches any of the
+// patterns given in --include options, or if there are none.
+//
+// Unlike the --exclude patterns, these apply only to files, because we must
+// visit every directory to find the files within it that match.
+func isIncluded(fn string) bool {
+	if len(includePatterns) == 0 {
+		return true
+	}
+	for _, pattern := range includePatterns {
+		// We validated the patterns in main, so we can ignore errors here.
+		if matched, _ := doublestar.PathMatch(pattern, fn); matched {
+			return true
+		}
+	}
+	return false
+}
+
+// processDir visits each of the entries in the directory with the given
+// name, which was found at the given depth, skipping any that are ignored by
+// the given rules.
+func (w *walker) processDir(fn string, ignores ignoreRules, depth int) {
+	entries, err := ioutil.ReadDir(fn)
+	if err != nil {
+		reportError(fn, "Failed to read directory %q: %s", fn, err)
+		return
+	}
+
+	ignores = ignores.withFilesIn(fn)
+	for _, entry := range entries {
+		if entry.IsDir() && (!recursive || (maxDepth >= 0 && depth >= maxDepth)) {
+			continue
+		}
+		entryFn := filepath.Join(fn, entry.Name())
+		if ignoredBy := ignores.ignoredBy(entryFn, entry.IsDir()); ignoredBy != "" {
+			logSkip(entryFn, "ignored by "+ignoredBy)
+			continue
+		}
+		w.processItem(entryFn, ignores, depth+1)
+	}
+}
+
+// expandArg returns the paths to visit for the given argument, which may be
+// either a literal path or a glob pattern that can include "**" to match any
+// number of directory levels.
+//
+// An argument that names an existing file or directory is always treated as
+// a literal path, even if it contains glob syntax. The result is empty if
+// the argument is a pattern that matches nothing.
+func expandArg(arg string) ([]string, error) {
+	if !strings.ContainsAny(arg, "*?[{") {
+		return []string{arg}, nil
+	}
+	if _, err := os.Lstat(arg); err == nil {
+		return []string{arg}, nil
+	}
+	return doublestar.Glob(arg)
+}
+
+// readFileList reads a list of filenames, one per line, from the file with
+// the given name, or from stdin if the name is "-". Empty lines are ignored.
+func readFileList(fn string) ([]string, error) {
+	var r io.Reader = os.Stdin
+	if fn != "-" {
+		f, err := os.Open(fn)
+		if err != nil {
+			return nil, err
+		}
+		defer f.Close()
+		r = f
+	}
+
+	var ret []string
+	sc := bufio.NewScanner(r)
+	for sc.Scan() {
+		line := strings.TrimRight(sc.Text(), "\r")
+		if line == "" {
+			continue
+		}
+		ret = append(ret, line)
+	}
+	return ret, sc.Err()
+}
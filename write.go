@@ -0,0 +1,74 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// writeFileAtomic replaces the content of the file with the given name
+// with the given data, by first writing the data to a temporary file in the
+// same directory and then renaming it over the original. The new file has
+// the same mode and, if possible, the same ownership as the original file,
+// which is described by the given info. With --preserve-mtime it also has
+// the original modification time, but otherwise its modification time is
+// the time it was written, as usual.
+//
+// Renaming is atomic on most filesystems, so the file will either have its
+// original content or its new content, even if we fail partway through.
+// If writeFileAtomic returns an error then the original file has not been
+// modified.
+func writeFileAtomic(fn string, data []byte, info os.FileInfo) error {
+	// If fn is a symbolic link, as it can be with --follow-symlinks, we
+	// must replace the file it refers to rather than the link itself.
+	if resolved, err := filepath.EvalSymlinks(fn); err == nil {
+		fn = resolved
+	}
+
+	dir, name := filepath.Split(fn)
+	if dir == "" {
+		dir = "."
+	}
+
+	// The temporary file name starts with a period and doesn't end in .tf,
+	// so we won't try to process it if something goes wrong and it gets
+	// left behind.
+	f, err := ioutil.TempFile(dir, "."+name+".*.tmp")
+	if err != nil {
+		return err
+	}
+	tmpFn := f.Name()
+
+	_, err = f.Write(data)
+	if err == nil {
+		// Without this, a crash soon after the rename could leave the file
+		// with its new name but without its new content on some
+		// filesystems, which is the very problem we're trying to avoid.
+		err = f.Sync()
+	}
+	if closeErr := f.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		// TempFile creates the file with restrictive permissions, so we
+		// need to reinstate the original file's mode.
+		err = os.Chmod(tmpFn, info.Mode().Perm())
+	}
+	if err == nil {
+		err = copyOwner(tmpFn, info)
+	}
+	if err == nil && preserveMtime {
+		// Go can't portably read the original access time, so we'll treat
+		// the file as having been accessed now, which it just was.
+		err = os.Chtimes(tmpFn, time.Now(), info.ModTime())
+	}
+	if err == nil {
+		err = os.Rename(tmpFn, fn)
+	}
+	if err != nil {
+		os.Remove(tmpFn)
+		return err
+	}
+	return nil
+}